@@ -0,0 +1,375 @@
+package spike
+
+
+ import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"encoding/binary"
+	"errors"
+) 
+
+type ChannelState int
+const (
+	Closed ChannelState = iota
+	Open
+)
+
+// The largest packet body accepted from the remote endpoint. The length is read
+// from the wire, so a corrupt or hostile peer must not make us allocate gigabytes.
+const maxPacketLength = 16 * 1024 * 1024
+
+// Represents a Channel to a Spike Engine server, over any transport which
+// provides a reliable stream of bytes.
+type Channel struct {
+	received int64 // Unix time of the last packet, first for atomic alignment
+	beating int32
+	staleServerTimes int32 // replies still due to timed out SampleClock requests
+	state int32 // a ChannelState, read and written atomically
+	conn io.ReadWriteCloser
+	guard *sync.Mutex
+	closing *sync.Once
+	authenticated atomic.Value // *Credentials last accepted, nil once revoked
+
+		
+	// Channel for PingInform messages
+	OnPing chan *PingInform 
+		
+	// Channel for GetServerTimeInform messages
+	OnGetServerTime chan *GetServerTimeInform 
+		
+	// Channel for SupplyCredentialsInform messages
+	OnSupplyCredentials chan *SupplyCredentialsInform 
+		
+	// Channel for RevokeCredentialsInform messages
+	OnRevokeCredentials chan *RevokeCredentialsInform 
+		
+	// Channel for HubSubscribeInform messages
+	OnHubSubscribe chan *HubSubscribeInform 
+		
+	// Channel for HubUnsubscribeInform messages
+	OnHubUnsubscribe chan *HubUnsubscribeInform 
+		
+	// Channel for HubPublishInform messages
+	OnHubPublish chan *HubPublishInform 
+		
+	// Channel for HubEventInform messages
+	OnHubEvent chan *HubEventInform 
+
+	// Channel which receives the error that closed the connection
+	OnDisconnect chan error
+}
+
+// Represents a way to establish the connection a Channel runs over.
+type Dialer interface {
+	// Connects to the address, in the format expected by the transport.
+	Dial(address string) (io.ReadWriteCloser, error)
+}
+
+// Represents a function which acts as a Dialer.
+type DialerFunc func(address string) (io.ReadWriteCloser, error)
+
+// Connects to the address by invoking the function.
+func (this DialerFunc) Dial(address string) (io.ReadWriteCloser, error) {
+	return this(address)
+}
+
+// Connects to the address using the dialer and opens a Channel over the connection.
+func Dial(dialer Dialer, address string, bufferSize int) (*Channel, error) {
+	conn, err := dialer.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewChannel(conn, bufferSize), nil
+}
+
+// Constructs a new Channel over an established connection, such as one end of a
+// net.Pipe, and starts listening.
+func NewChannel(conn io.ReadWriteCloser, bufferSize int) *Channel {
+	channel := new(Channel)
+	channel.open(conn, bufferSize)
+	return channel
+}
+
+// Prepares the channel on an established connection and starts listening
+func (this *Channel) open(conn io.ReadWriteCloser, bufferSize int) {
+	// Default is 8K
+	if (bufferSize == 0){
+		bufferSize = 8192
+	}
+
+	// The number of pending events per channel
+	slots := 2048
+
+	// Create the necessary channels
+	this.OnPing = make(chan *PingInform, slots)
+	this.OnGetServerTime = make(chan *GetServerTimeInform, slots)
+	this.OnSupplyCredentials = make(chan *SupplyCredentialsInform, slots)
+	this.OnRevokeCredentials = make(chan *RevokeCredentialsInform, slots)
+	this.OnHubSubscribe = make(chan *HubSubscribeInform, slots)
+	this.OnHubUnsubscribe = make(chan *HubUnsubscribeInform, slots)
+	this.OnHubPublish = make(chan *HubPublishInform, slots)
+	this.OnHubEvent = make(chan *HubEventInform, slots)
+	this.OnDisconnect = make(chan error, 1)
+	
+	atomic.StoreInt32(&this.state, int32(Open))
+	this.conn = conn
+	this.guard = new(sync.Mutex)
+	this.closing = new(sync.Once)
+	atomic.StoreInt64(&this.received, time.Now().UnixNano())
+
+	// Listen
+	go this.listen(bufferSize)
+}
+
+
+// Returns whether the channel is open, from any goroutine
+func (this *Channel) IsOpen() bool {
+	return ChannelState(atomic.LoadInt32(&this.state)) == Open
+}
+
+// Disconnects from the remote endpoint
+func (this *Channel) Disconnect() (error){
+	if (!this.IsOpen() || this.conn == nil){
+		return nil
+	}
+
+	return this.conn.Close()
+}
+
+// Reads from the remote server
+func (this *Channel) listen(bufferSize int) error {
+	reader := bufio.NewReaderSize(this.conn, bufferSize)
+	header := make([]byte, 8)
+
+	for {
+		// Read the header, the packets may span several reads
+		if _, err := io.ReadFull(reader, header); err != nil {
+			// EOF means the remote endpoint has closed the connection
+			return this.close(err)
+		}
+
+		// The length includes the key
+		length := int32(binary.BigEndian.Uint32(header[0:4])) - 4
+		key := binary.BigEndian.Uint32(header[4:8])
+		if length < 0 {
+			return this.close(errors.New("spike.listen: invalid packet length"))
+		}
+		if length > maxPacketLength {
+			return this.close(errors.New("spike.listen: packet too large"))
+		}
+
+		// Read the body and forward to receive
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return this.close(err)
+		}
+		atomic.StoreInt64(&this.received, time.Now().UnixNano())
+		this.onReceive(key, body)
+	}
+}
+
+// Closes the connection and notifies about the disconnection, only once
+func (this *Channel) close(err error) error {
+	this.closing.Do(func() {
+		this.conn.Close()
+		atomic.StoreInt32(&this.state, int32(Closed))
+
+		select {
+			case this.OnDisconnect <- err:
+			default:
+		}
+	})
+	return err
+}
+
+// Occurs when a packet is received
+func (this *Channel) onReceive(key uint32, buffer []byte) error{
+	reader := NewPacketReader(buffer)
+	switch (key) {
+	
+		case 0xB0AF6283: {
+			packet := new(PingInform)
+			packet.Time, _ = reader.ReadInt32()
+			if packet.Time == heartbeatTime {
+				return nil
+			}
+	
+			select {
+    			case this.OnPing <- packet:
+    			default:
+    		}
+			return nil
+		}
+	
+		case 0x33E7FBD1: {
+			reader.Decompress()
+			packet := new(GetServerTimeInform)
+			packet.ServerTime, _ = reader.ReadDateTime()
+	
+			select {
+    			case this.OnGetServerTime <- packet:
+    			default:
+    		}
+			return nil
+		}
+	
+		case 0x8D98E9FC: {
+			packet := new(SupplyCredentialsInform)
+			packet.Result, _ = reader.ReadBoolean()
+	
+			select {
+    			case this.OnSupplyCredentials <- packet:
+    			default:
+    		}
+			return nil
+		}
+	
+		case 0x4AC51818: {
+			packet := new(RevokeCredentialsInform)
+			packet.Result, _ = reader.ReadBoolean()
+	
+			select {
+    			case this.OnRevokeCredentials <- packet:
+    			default:
+    		}
+			return nil
+		}
+	
+		case 0x2DD19B9B: {
+			packet := new(HubSubscribeInform)
+			packet.Status, _ = reader.ReadInt16()
+	
+			select {
+    			case this.OnHubSubscribe <- packet:
+    			default:
+    		}
+			return nil
+		}
+	
+		case 0x6C63B75: {
+			packet := new(HubUnsubscribeInform)
+			packet.Status, _ = reader.ReadInt16()
+	
+			select {
+    			case this.OnHubUnsubscribe <- packet:
+    			default:
+    		}
+			return nil
+		}
+	
+		case 0x96B41079: {
+			packet := new(HubPublishInform)
+			packet.Status, _ = reader.ReadInt16()
+	
+			select {
+    			case this.OnHubPublish <- packet:
+    			default:
+    		}
+			return nil
+		}
+	
+		case 0x65B2818C: {
+			reader.Decompress()
+			packet := new(HubEventInform)
+			packet.HubName, _ = reader.ReadString()
+			packet.Message, _ = reader.ReadString()
+			packet.Time, _ = reader.ReadDateTime()
+	
+			select {
+    			case this.OnHubEvent <- packet:
+    			default:
+    		}
+			return nil
+		}
+	}
+
+	return errors.New("spike.onReceive: Unknown packet received")
+}
+
+// Sends a packet using the writer, or returns an error if the channel is closed
+func (this *Channel) sendPacket(key uint32, writer *PacketWriter) error {
+	len := writer.buffer.Len() + 4
+	if (!this.IsOpen()){
+		return errors.New("spike.sendPacket: socket is not connected")
+	}
+
+	header := make([]byte, 8)
+	header[0] = byte(len >> 24)
+	header[1] = byte(len >> 16)
+	header[2] = byte(len >> 8)
+	header[3] = byte(len)
+	header[4] = byte(key >> 24)
+	header[5] = byte(key >> 16)
+	header[6] = byte(key >> 8)
+	header[7] = byte(key)
+
+	// Write the packet at once, message-based transports expect a packet per write
+	packet := append(header, writer.buffer.Bytes()...)
+
+	// Make sure this part is synchronized
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	_, err := this.conn.Write(packet)
+	return err
+}
+
+
+		
+func (this *Channel) Ping(Time int32) error {
+	writer := NewPacketWriter()
+	writer.WriteInt32(Time)
+	return this.sendPacket(0xB0AF6283 , writer)
+}		 
+		
+func (this *Channel) GetServerTime() error {
+	writer := NewPacketWriter()
+	return this.sendPacket(0x33E7FBD1 , writer)
+}		 
+		
+func (this *Channel) SupplyCredentials(CredentialsUri string, CredentialsType string, UserName string, Password string, Domain string) error {
+	writer := NewPacketWriter()
+	writer.WriteString(CredentialsUri)
+	writer.WriteString(CredentialsType)
+	writer.WriteString(UserName)
+	writer.WriteString(Password)
+	writer.WriteString(Domain)
+	writer.Compress()
+	return this.sendPacket(0x8D98E9FC , writer)
+}		 
+		
+func (this *Channel) RevokeCredentials(CredentialsUri string, CredentialsType string) error {
+	writer := NewPacketWriter()
+	writer.WriteString(CredentialsUri)
+	writer.WriteString(CredentialsType)
+	writer.Compress()
+	return this.sendPacket(0x4AC51818 , writer)
+}		 
+		
+func (this *Channel) HubSubscribe(HubName string, SubscribeKey string) error {
+	writer := NewPacketWriter()
+	writer.WriteString(HubName)
+	writer.WriteString(SubscribeKey)
+	writer.Compress()
+	return this.sendPacket(0x2DD19B9B , writer)
+}		 
+		
+func (this *Channel) HubUnsubscribe(HubName string, SubscribeKey string) error {
+	writer := NewPacketWriter()
+	writer.WriteString(HubName)
+	writer.WriteString(SubscribeKey)
+	writer.Compress()
+	return this.sendPacket(0x6C63B75 , writer)
+}		 
+		
+func (this *Channel) HubPublish(HubName string, PublishKey string, Message string) error {
+	writer := NewPacketWriter()
+	writer.WriteString(HubName)
+	writer.WriteString(PublishKey)
+	writer.WriteString(Message)
+	writer.Compress()
+	return this.sendPacket(0x96B41079 , writer)
+}
\ No newline at end of file
@@ -0,0 +1,142 @@
+package spike
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// The keys of the packets tested here, besides the credentials and server time.
+const (
+	pingKey         uint32 = 0xB0AF6283
+	hubSubscribeKey uint32 = 0x2DD19B9B
+	hubPublishKey   uint32 = 0x96B41079
+	hubEventKey     uint32 = 0x65B2818C
+)
+
+func TestChannelFramesRequests(t *testing.T) {
+	channel, server := newPipeChannel(t)
+
+	go channel.Ping(42)
+	if value, _ := server.expect(pingKey).ReadInt32(); value != 42 {
+		t.Errorf("pinged with %d, want 42", value)
+	}
+
+	go channel.HubPublish("news", "key", "hello")
+	reader := server.expect(hubPublishKey)
+	reader.Decompress()
+	hub, _ := reader.ReadString()
+	key, _ := reader.ReadString()
+	message, _ := reader.ReadString()
+	if hub != "news" || key != "key" || message != "hello" {
+		t.Errorf("published %q, %q and %q", hub, key, message)
+	}
+}
+
+func TestChannelDispatchesInforms(t *testing.T) {
+	channel, server := newPipeChannel(t)
+
+	ping := NewPacketWriter()
+	ping.WriteInt32(7)
+	server.write(pingKey, ping)
+
+	status := NewPacketWriter()
+	status.WriteInt16(3)
+	server.write(hubSubscribeKey, status)
+
+	event := NewPacketWriter()
+	event.WriteString("news")
+	event.WriteString("hello")
+	event.WriteDateTime(time.Now())
+	event.Compress()
+	server.write(hubEventKey, event)
+
+	server.write(revokeCredentialsKey, booleanBody(true))
+
+	select {
+	case msg := <-channel.OnPing:
+		if msg.Time != 7 {
+			t.Errorf("received the ping %d, want 7", msg.Time)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the ping was not dispatched")
+	}
+	select {
+	case msg := <-channel.OnHubSubscribe:
+		if msg.Status != 3 {
+			t.Errorf("received the status %d, want 3", msg.Status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the subscription status was not dispatched")
+	}
+	select {
+	case msg := <-channel.OnHubEvent:
+		if msg.HubName != "news" || msg.Message != "hello" {
+			t.Errorf("received %q on hub %q", msg.Message, msg.HubName)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the hub event was not dispatched")
+	}
+	select {
+	case msg := <-channel.OnRevokeCredentials:
+		if !msg.Result {
+			t.Error("the revocation was not confirmed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the revocation was not dispatched")
+	}
+}
+
+func TestChannelReassemblesPackets(t *testing.T) {
+	channel, server := newPipeChannel(t)
+
+	// Two packets, written in pieces which straddle the headers and bodies
+	first := NewPacketWriter()
+	first.WriteInt32(1)
+	second := NewPacketWriter()
+	second.WriteInt32(2)
+	data := append(frame(pingKey, first.Bytes()), frame(pingKey, second.Bytes())...)
+	go func() {
+		for _, piece := range [][]byte{data[:3], data[3:10], data[10:17], data[17:]} {
+			if err := server.writeRaw(piece); err != nil {
+				return
+			}
+		}
+	}()
+
+	for want := int32(1); want <= 2; want++ {
+		select {
+		case msg := <-channel.OnPing:
+			if msg.Time != want {
+				t.Errorf("received the ping %d, want %d", msg.Time, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the ping %d was not reassembled", want)
+		}
+	}
+}
+
+func TestChannelRejectsOversizedPackets(t *testing.T) {
+	channel, server := newPipeChannel(t)
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], 0x7FFFFFFF)
+	binary.BigEndian.PutUint32(header[4:8], pingKey)
+	go server.writeRaw(header)
+
+	select {
+	case err := <-channel.OnDisconnect:
+		if err == nil {
+			t.Error("disconnected without an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the oversized packet was accepted")
+	}
+
+	if channel.IsOpen() {
+		t.Error("the channel is still open")
+	}
+	if err := channel.Ping(1); err == nil {
+		t.Error("a packet was sent on a closed channel")
+	}
+}
@@ -0,0 +1,189 @@
+package spike
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// The resolution of the server time, which is transmitted in milliseconds.
+const serverTimeResolution = time.Millisecond
+
+// Represents a single GetServerTime exchange, timed with the local clock.
+type ClockSample struct {
+	// The local time at which the request was sent.
+	Sent time.Time
+
+	// The local time at which the response was received.
+	Received time.Time
+
+	// The time reported by the server.
+	Server time.Time
+}
+
+// Gets the round-trip time of the exchange.
+func (this ClockSample) Rtt() time.Duration {
+	return this.Received.Sub(this.Sent)
+}
+
+// Gets the offset of the server clock relative to the local clock, assuming the
+// server read its clock halfway through the exchange. The server time is truncated
+// to the millisecond, so the middle of that millisecond is used.
+func (this ClockSample) Offset() time.Duration {
+	middle := this.Sent.Add(this.Rtt() / 2)
+	return this.Server.Add(serverTimeResolution / 2).Sub(middle)
+}
+
+// Gets the maximum error of the offset of this sample.
+func (this ClockSample) Uncertainty() time.Duration {
+	return this.Rtt()/2 + serverTimeResolution/2
+}
+
+// Requests the server time and times the exchange with the local clock. The replies
+// carry no tag, so the replies still due to the requests which timed out earlier are
+// discarded first, rather than paired with the send time of this request.
+func (this *Channel) SampleClock(timeout time.Duration) (ClockSample, error) {
+	for atomic.LoadInt32(&this.staleServerTimes) > 0 {
+		select {
+		case <-this.OnGetServerTime:
+			atomic.AddInt32(&this.staleServerTimes, -1)
+			continue
+		default:
+		}
+		break
+	}
+
+	sample := ClockSample{Sent: time.Now()}
+	if err := this.GetServerTime(); err != nil {
+		return sample, err
+	}
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-this.OnGetServerTime:
+			if atomic.LoadInt32(&this.staleServerTimes) > 0 {
+				atomic.AddInt32(&this.staleServerTimes, -1)
+				continue
+			}
+			sample.Received = time.Now()
+			sample.Server = msg.ServerTime
+			return sample, nil
+		case <-deadline:
+			atomic.AddInt32(&this.staleServerTimes, 1)
+			return sample, errors.New("spike: timed out waiting for the server time")
+		}
+	}
+}
+
+// Represents an estimation of the server clock relative to the local clock.
+type ClockEstimate struct {
+	// The estimated offset of the server clock; positive if the server is ahead.
+	Offset time.Duration
+
+	// The maximum error of the estimated offset.
+	Uncertainty time.Duration
+
+	// The drift of the server clock relative to the local clock, in parts per million;
+	// positive if the server clock runs faster. Zero if it could not be estimated.
+	Drift float64
+
+	// The number of samples the offset was estimated from.
+	Samples int
+}
+
+// Estimates the server clock from the samples. Only the 'best' samples with the
+// lowest round-trip time are used for the offset, as they have the least room for
+// queuing delays and asymmetry.
+func EstimateClock(samples []ClockSample, best int) (ClockEstimate, error) {
+	if len(samples) == 0 {
+		return ClockEstimate{}, errors.New("spike: no clock samples to estimate from")
+	}
+	if best <= 0 || best > len(samples) {
+		best = len(samples)
+	}
+
+	sorted := append([]ClockSample{}, samples...)
+	sort.Sort(byRtt(sorted))
+
+	// The drift is fitted on the faster half, which spans more time than the best samples
+	half := sorted[:(len(sorted)+1)/2]
+	sorted = sorted[:best]
+
+	// The true offset lies within the uncertainty of every sample, so intersect them
+	low, high := time.Duration(math.MinInt64), time.Duration(math.MaxInt64)
+	sum := time.Duration(0)
+	for _, sample := range sorted {
+		if l := sample.Offset() - sample.Uncertainty(); l > low {
+			low = l
+		}
+		if h := sample.Offset() + sample.Uncertainty(); h < high {
+			high = h
+		}
+		sum += sample.Offset()
+	}
+
+	estimate := ClockEstimate{Samples: best, Drift: estimateDrift(half)}
+	if low <= high {
+		estimate.Offset = (low + high) / 2
+		estimate.Uncertainty = (high - low) / 2
+	} else {
+		// The intervals do not overlap, most likely because the clocks drifted apart
+		estimate.Offset = sum / time.Duration(best)
+		estimate.Uncertainty = sorted[best-1].Uncertainty()
+	}
+	return estimate, nil
+}
+
+// Converts a time read on the server clock to the local clock.
+func (this ClockEstimate) ToLocal(server time.Time) time.Time {
+	return server.Add(-this.Offset)
+}
+
+// Splits an exchange which the server timestamped into its upstream and downstream
+// one-way delays, such as a hub message published at 'sent' and delivered back at
+// 'received' with the server time of the event. The server time is truncated to the
+// millisecond, so the middle of that millisecond is used.
+func (this ClockEstimate) OneWay(sent time.Time, server time.Time, received time.Time) (upstream time.Duration, downstream time.Duration) {
+	local := this.ToLocal(server.Add(serverTimeResolution / 2))
+	return local.Sub(sent), received.Sub(local)
+}
+
+// Estimates the drift as the slope of the least squares fit of the offsets over time.
+func estimateDrift(samples []ClockSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+
+	origin := samples[0].Sent
+	for _, sample := range samples {
+		if sample.Sent.Before(origin) {
+			origin = sample.Sent
+		}
+	}
+
+	var sx, sy, sxx, sxy float64
+	for _, sample := range samples {
+		x := sample.Sent.Sub(origin).Seconds()
+		y := sample.Offset().Seconds()
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+
+	n := float64(len(samples))
+	denominator := n*sxx - sx*sx
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / denominator * 1e6
+}
+
+// Sorts the clock samples by round-trip time.
+type byRtt []ClockSample
+
+func (this byRtt) Len() int           { return len(this) }
+func (this byRtt) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
+func (this byRtt) Less(i, j int) bool { return this[i].Rtt() < this[j].Rtt() }
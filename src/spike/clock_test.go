@@ -0,0 +1,43 @@
+package spike
+
+import (
+	"testing"
+	"time"
+)
+
+// The key of the GetServerTime packets.
+const getServerTimeKey uint32 = 0x33E7FBD1
+
+// Returns a compressed GetServerTime reply holding the time.
+func serverTimeBody(value time.Time) *PacketWriter {
+	writer := NewPacketWriter()
+	writer.WriteDateTime(value)
+	writer.Compress()
+	return writer
+}
+
+func TestSampleClockDiscardsStaleReplies(t *testing.T) {
+	channel, server := newPipeChannel(t)
+	stale := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	fresh := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The first request is left unanswered until it times out
+	go server.expect(getServerTimeKey)
+	if _, err := channel.SampleClock(50 * time.Millisecond); err == nil {
+		t.Fatal("an unanswered request returned a sample")
+	}
+
+	// Its reply only arrives once the next request was sent
+	go func() {
+		server.expect(getServerTimeKey)
+		server.write(getServerTimeKey, serverTimeBody(stale))
+		server.write(getServerTimeKey, serverTimeBody(fresh))
+	}()
+	sample, err := channel.SampleClock(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.Server.Year() != fresh.Year() {
+		t.Errorf("the sample was paired with the reply %v, want %v", sample.Server, fresh)
+	}
+}
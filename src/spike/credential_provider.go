@@ -0,0 +1,122 @@
+package spike
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Represents a source of credentials, queried every time they are supplied so
+// that rotated secrets are picked up without restarting.
+type CredentialProvider interface {
+	// Gets the credentials to supply.
+	Credentials() (Credentials, error)
+}
+
+// ------------------ Static ------------------------
+
+type staticCredentialProvider struct {
+	credentials Credentials
+}
+
+// Constructs a provider which always returns the same credentials.
+func NewStaticCredentialProvider(credentials Credentials) CredentialProvider {
+	return &staticCredentialProvider{credentials}
+}
+
+func (this *staticCredentialProvider) Credentials() (Credentials, error) {
+	return this.credentials, nil
+}
+
+// ------------------ Environment ------------------------
+
+type envCredentialProvider struct {
+	prefix string
+}
+
+// Constructs a provider which reads the credentials from the environment variables
+// <prefix>USER, <prefix>PASSWORD, <prefix>DOMAIN, <prefix>CREDENTIALS_URI and
+// <prefix>CREDENTIALS_TYPE, such as 'SPING_USER' for the 'SPING_' prefix.
+func NewEnvCredentialProvider(prefix string) CredentialProvider {
+	return &envCredentialProvider{prefix}
+}
+
+func (this *envCredentialProvider) Credentials() (Credentials, error) {
+	credentials := Credentials{
+		Uri:      os.Getenv(this.prefix + "CREDENTIALS_URI"),
+		Type:     os.Getenv(this.prefix + "CREDENTIALS_TYPE"),
+		UserName: os.Getenv(this.prefix + "USER"),
+		Password: os.Getenv(this.prefix + "PASSWORD"),
+		Domain:   os.Getenv(this.prefix + "DOMAIN"),
+	}
+
+	if credentials.UserName == "" {
+		return credentials, errors.New("spike: environment variable " + this.prefix + "USER is not set")
+	}
+	return credentials, nil
+}
+
+// ------------------ File ------------------------
+
+type fileCredentialProvider struct {
+	credentials Credentials
+	path        string
+}
+
+// Constructs a provider which reads the password from a file, on top of the
+// credentials provided. The file must not be accessible by the group or others.
+func NewFileCredentialProvider(credentials Credentials, path string) CredentialProvider {
+	return &fileCredentialProvider{credentials, path}
+}
+
+func (this *fileCredentialProvider) Credentials() (Credentials, error) {
+	credentials := this.credentials
+	info, err := os.Stat(this.path)
+	if err != nil {
+		return credentials, err
+	}
+
+	// Windows does not have the permission bits, the ACLs are not checked
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return credentials, errors.New("spike: password file " + this.path + " is accessible by other users, its mode should be 0600")
+	}
+
+	password, err := ioutil.ReadFile(this.path)
+	if err != nil {
+		return credentials, err
+	}
+
+	credentials.Password = strings.TrimRight(string(password), "\r\n")
+	return credentials, nil
+}
+
+// ------------------ Command ------------------------
+
+type commandCredentialProvider struct {
+	credentials Credentials
+	name        string
+	args        []string
+}
+
+// Constructs a provider which runs an external command, such as a secret manager
+// client, and uses the first line of its output as the password, on top of the
+// credentials provided.
+func NewCommandCredentialProvider(credentials Credentials, name string, args ...string) CredentialProvider {
+	return &commandCredentialProvider{credentials, name, args}
+}
+
+func (this *commandCredentialProvider) Credentials() (Credentials, error) {
+	credentials := this.credentials
+	command := exec.Command(this.name, this.args...)
+	command.Stderr = os.Stderr
+	output, err := command.Output()
+	if err != nil {
+		return credentials, errors.New("spike: password command failed: " + err.Error())
+	}
+
+	credentials.Password = strings.TrimRight(strings.SplitN(string(output), "\n", 2)[0], "\r")
+	return credentials, nil
+}
@@ -0,0 +1,59 @@
+package spike
+
+import (
+	"errors"
+	"time"
+)
+
+// Represents a set of credentials supplied to a Spike Engine service.
+type Credentials struct {
+	Uri      string
+	Type     string
+	UserName string
+	Password string
+	Domain   string
+}
+
+// Supplies the credentials and waits for the server to accept them.
+func (this *Channel) Authenticate(credentials Credentials, timeout time.Duration) error {
+	if err := this.SupplyCredentials(credentials.Uri, credentials.Type, credentials.UserName, credentials.Password, credentials.Domain); err != nil {
+		return err
+	}
+	select {
+	case msg := <-this.OnSupplyCredentials:
+		if !msg.Result {
+			return errors.New("spike: credentials of user '" + credentials.UserName + "' were rejected")
+		}
+		this.authenticated.Store(&credentials)
+		return nil
+	case <-time.After(timeout):
+		return errors.New("spike: timed out waiting for the credentials to be accepted")
+	}
+}
+
+// Revokes the credentials previously supplied and waits for the server to confirm.
+func (this *Channel) Revoke(credentials Credentials, timeout time.Duration) error {
+	if err := this.RevokeCredentials(credentials.Uri, credentials.Type); err != nil {
+		return err
+	}
+	select {
+	case msg := <-this.OnRevokeCredentials:
+		if !msg.Result {
+			return errors.New("spike: credentials of user '" + credentials.UserName + "' could not be revoked")
+		}
+		this.authenticated.Store((*Credentials)(nil))
+		return nil
+	case <-time.After(timeout):
+		return errors.New("spike: timed out waiting for the credentials to be revoked")
+	}
+}
+
+// Returns the credentials the server last accepted on this channel, so exactly
+// those are revoked. Returns false if none were, or if they were revoked.
+func (this *Channel) Authenticated() (Credentials, bool) {
+	credentials, _ := this.authenticated.Load().(*Credentials)
+	if credentials == nil {
+		return Credentials{}, false
+	}
+	return *credentials, true
+}
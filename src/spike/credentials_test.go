@@ -0,0 +1,71 @@
+package spike
+
+import (
+	"testing"
+	"time"
+)
+
+// The keys of the credentials packets.
+const (
+	supplyCredentialsKey uint32 = 0x8D98E9FC
+	revokeCredentialsKey uint32 = 0x4AC51818
+)
+
+// Reads a SupplyCredentials request and returns the user name it carries.
+func readSupplied(server *pipeServer) string {
+	reader := server.expect(supplyCredentialsKey)
+	reader.Decompress()
+	reader.ReadString()
+	reader.ReadString()
+	user, _ := reader.ReadString()
+	return user
+}
+
+func TestAuthenticatedKeepsTheAcceptedCredentials(t *testing.T) {
+	channel, server := newPipeChannel(t)
+	first := Credentials{Uri: "uri:first", Type: "basic", UserName: "first", Password: "one"}
+	second := Credentials{Uri: "uri:second", Type: "basic", UserName: "second", Password: "two"}
+
+	if _, ok := channel.Authenticated(); ok {
+		t.Error("a fresh channel is authenticated")
+	}
+
+	go func() {
+		readSupplied(server)
+		server.write(supplyCredentialsKey, booleanBody(true))
+	}()
+	if err := channel.Authenticate(first, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rejected credentials do not replace the accepted ones
+	go func() {
+		readSupplied(server)
+		server.write(supplyCredentialsKey, booleanBody(false))
+	}()
+	if err := channel.Authenticate(second, time.Second); err == nil {
+		t.Fatal("rejected credentials were accepted")
+	}
+	if got, ok := channel.Authenticated(); !ok || got != first {
+		t.Fatalf("authenticated with %+v, want %+v", got, first)
+	}
+
+	// The revocation names the credentials which were accepted
+	revoked := make(chan string, 1)
+	go func() {
+		reader := server.expect(revokeCredentialsKey)
+		reader.Decompress()
+		uri, _ := reader.ReadString()
+		revoked <- uri
+		server.write(revokeCredentialsKey, booleanBody(true))
+	}()
+	if err := channel.Revoke(first, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if uri := <-revoked; uri != first.Uri {
+		t.Errorf("revoked %q, want %q", uri, first.Uri)
+	}
+	if _, ok := channel.Authenticated(); ok {
+		t.Error("the channel is still authenticated after the revocation")
+	}
+}
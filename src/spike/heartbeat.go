@@ -0,0 +1,50 @@
+package spike
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// The time carried by the heartbeat pings, so their informs are not delivered
+// on OnPing along with the ones of the application.
+const heartbeatTime int32 = math.MinInt32
+
+// The error received on OnDisconnect when the peer is declared dead.
+var ErrHeartbeatTimeout = errors.New("spike: nothing received within the heartbeat timeout, the peer is considered dead")
+
+// Starts a background heartbeat which pings the server whenever nothing was
+// received for the interval, and closes the channel with ErrHeartbeatTimeout
+// if nothing is received for the timeout. This detects half-open connections,
+// which otherwise look healthy forever. The heartbeat stops once the channel
+// is closed.
+func (this *Channel) StartHeartbeat(interval time.Duration, timeout time.Duration) {
+	go this.heartbeat(interval, timeout)
+}
+
+// Pings the server while the channel is idle and closes it once the peer is dead.
+func (this *Channel) heartbeat(interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !this.IsOpen() {
+			return
+		}
+
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&this.received)))
+		if idle >= timeout {
+			this.close(ErrHeartbeatTimeout)
+			return
+		}
+
+		// A write to a dead peer may block, so at most one ping is in flight
+		if idle >= interval && atomic.CompareAndSwapInt32(&this.beating, 0, 1) {
+			go func() {
+				defer atomic.StoreInt32(&this.beating, 0)
+				this.Ping(heartbeatTime)
+			}()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package spike
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatDeclaresASilentPeerDead(t *testing.T) {
+	channel, _ := newPipeChannel(t)
+
+	// The server end never reads nor answers, so the heartbeat pings block
+	channel.StartHeartbeat(10*time.Millisecond, 50*time.Millisecond)
+	select {
+	case err := <-channel.OnDisconnect:
+		if err != ErrHeartbeatTimeout {
+			t.Errorf("disconnected with %v, want the heartbeat timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the silent peer was not declared dead")
+	}
+	if channel.IsOpen() {
+		t.Error("the channel is still open")
+	}
+}
+
+func TestHeartbeatKeepsAnAnsweringPeer(t *testing.T) {
+	channel, server := newPipeChannel(t)
+
+	// The pings come back as pongs once echoed
+	go io.Copy(server.conn, server.conn)
+
+	channel.StartHeartbeat(10*time.Millisecond, 50*time.Millisecond)
+	select {
+	case err := <-channel.OnDisconnect:
+		t.Fatalf("an answering peer was declared dead: %v", err)
+	case msg := <-channel.OnPing:
+		t.Errorf("the heartbeat pong %d was delivered to the application", msg.Time)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
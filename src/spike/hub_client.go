@@ -0,0 +1,367 @@
+package spike
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Represents an active hub subscription.
+type hubSubscription struct {
+	key     string
+	events  chan *HubEventInform
+	handler func(*HubEventInform)
+}
+
+// Represents a client which manages the hub subscriptions on top of a Channel,
+// routes the hub events to their subscribers and subscribes again after a reconnect.
+type HubClient struct {
+	// Gets or sets how long to wait for the server to acknowledge a request.
+	Timeout time.Duration
+
+	// Gets or sets the dialer used to (re)connect, TCP/IP by default.
+	Dialer Dialer
+
+	// Gets or sets the delay between two reconnection attempts.
+	ReconnectDelay time.Duration
+
+	// Gets or sets the interval of the heartbeat which detects a dead peer and
+	// reconnects. No heartbeat is sent if zero.
+	Heartbeat time.Duration
+
+	// Gets or sets how long nothing may be received before the peer is declared
+	// dead, three heartbeat intervals if zero.
+	HeartbeatTimeout time.Duration
+
+	// Gets or sets the provider of the credentials supplied on every (re)connection,
+	// before subscribing again to the hubs. No credentials are supplied if nil.
+	Credentials CredentialProvider
+
+	// Channel which receives the errors that occur in the background, such as
+	// failed reconnections and resubscriptions.
+	OnError chan error
+
+	// Channel which is notified every time the client has reconnected.
+	OnReconnect chan struct{}
+
+	address       string
+	bufferSize    int
+	channel       *Channel
+	guard         *sync.Mutex
+	sending       *sync.Mutex
+	closed        bool
+	subscriptions map[string]*hubSubscription
+	subscribes    []chan int16
+	unsubscribes  []chan int16
+	publishes     []chan int16
+}
+
+// Constructs a new hub client for the address.
+func NewHubClient(address string, bufferSize int) *HubClient {
+	client := new(HubClient)
+	client.Dialer = TcpDialer{}
+	client.Timeout = 5 * time.Second
+	client.ReconnectDelay = time.Second
+	client.OnError = make(chan error, 64)
+	client.OnReconnect = make(chan struct{}, 1)
+	client.address = address
+	client.bufferSize = bufferSize
+	client.guard = new(sync.Mutex)
+	client.sending = new(sync.Mutex)
+	client.subscriptions = make(map[string]*hubSubscription)
+	return client
+}
+
+// Connects to the remote endpoint.
+func (this *HubClient) Connect() error {
+	this.guard.Lock()
+	this.closed = false
+	this.guard.Unlock()
+	return this.dial()
+}
+
+// Dials the remote endpoint and starts routing the messages of the new channel.
+func (this *HubClient) dial() error {
+	channel, err := Dial(this.Dialer, this.address, this.bufferSize)
+	if err != nil {
+		return err
+	}
+
+	if this.Credentials != nil {
+		credentials, err := this.Credentials.Credentials()
+		if err == nil {
+			err = channel.Authenticate(credentials, this.Timeout)
+		}
+		if err != nil {
+			channel.Disconnect()
+			return err
+		}
+	}
+
+	if this.Heartbeat > 0 {
+		timeout := this.HeartbeatTimeout
+		if timeout == 0 {
+			timeout = 3 * this.Heartbeat
+		}
+		channel.StartHeartbeat(this.Heartbeat, timeout)
+	}
+
+	this.guard.Lock()
+	this.channel = channel
+	this.guard.Unlock()
+
+	go this.dispatch(channel)
+	return nil
+}
+
+// Gets the channel currently used by the client.
+func (this *HubClient) Channel() *Channel {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	return this.channel
+}
+
+// Subscribes to the hub and returns the channel on which the hub events are delivered.
+func (this *HubClient) Subscribe(hub string, key string) (<-chan *HubEventInform, error) {
+	events := make(chan *HubEventInform, 2048)
+	if err := this.subscribe(hub, &hubSubscription{key: key, events: events}); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Subscribes to the hub and invokes the handler for every hub event.
+func (this *HubClient) SubscribeFunc(hub string, key string, handler func(*HubEventInform)) error {
+	return this.subscribe(hub, &hubSubscription{key: key, handler: handler})
+}
+
+// Subscribes to the hub and registers the subscription once acknowledged.
+func (this *HubClient) subscribe(hub string, subscription *hubSubscription) error {
+	status, err := this.request(&this.subscribes, func(channel *Channel) error {
+		return channel.HubSubscribe(hub, subscription.key)
+	})
+	if err != nil {
+		return err
+	}
+	if err := HubStatus(status).Err(HubOperationSubscribe, hub); err != nil {
+		return err
+	}
+
+	this.guard.Lock()
+	this.subscriptions[hub] = subscription
+	this.guard.Unlock()
+	return nil
+}
+
+// Unsubscribes from the hub. No more events are delivered to the subscription afterwards.
+func (this *HubClient) Unsubscribe(hub string) error {
+	this.guard.Lock()
+	subscription, ok := this.subscriptions[hub]
+	this.guard.Unlock()
+	if !ok {
+		return errors.New("spike: not subscribed to hub '" + hub + "'")
+	}
+
+	status, err := this.request(&this.unsubscribes, func(channel *Channel) error {
+		return channel.HubUnsubscribe(hub, subscription.key)
+	})
+	if err != nil {
+		return err
+	}
+	if err := HubStatus(status).Err(HubOperationUnsubscribe, hub); err != nil {
+		return err
+	}
+
+	this.guard.Lock()
+	delete(this.subscriptions, hub)
+	this.guard.Unlock()
+	return nil
+}
+
+// Publishes a message to the hub and waits for the acknowledgement.
+func (this *HubClient) Publish(hub string, key string, message string) error {
+	status, err := this.request(&this.publishes, func(channel *Channel) error {
+		return channel.HubPublish(hub, key, message)
+	})
+	if err != nil {
+		return err
+	}
+	if err := HubStatus(status).Err(HubOperationPublish, hub); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns the names of the hubs the client is subscribed to.
+func (this *HubClient) Subscriptions() []string {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	hubs := make([]string, 0, len(this.subscriptions))
+	for hub := range this.subscriptions {
+		hubs = append(hubs, hub)
+	}
+	return hubs
+}
+
+// Disconnects from the remote endpoint and stops reconnecting.
+func (this *HubClient) Close() error {
+	this.guard.Lock()
+	this.closed = true
+	channel := this.channel
+	this.guard.Unlock()
+
+	if channel == nil {
+		return nil
+	}
+	return channel.Disconnect()
+}
+
+// Sends a request and waits for its status. The server acknowledges the requests
+// in order, so the requests are sent in the order their waiters are queued. The
+// write may block, so it happens outside of the guard the dispatch loop needs.
+func (this *HubClient) request(queue *[]chan int16, send func(*Channel) error) (int16, error) {
+	waiter := make(chan int16, 1)
+
+	this.sending.Lock()
+	this.guard.Lock()
+	channel := this.channel
+	if channel == nil || this.closed || !channel.IsOpen() {
+		this.guard.Unlock()
+		this.sending.Unlock()
+		return 0, errors.New("spike: hub client is not connected")
+	}
+	*queue = append(*queue, waiter)
+	this.guard.Unlock()
+	err := send(channel)
+	this.sending.Unlock()
+	if err != nil {
+		this.forget(queue, waiter)
+		return 0, err
+	}
+
+	select {
+	case status, ok := <-waiter:
+		if !ok {
+			return 0, errors.New("spike: connection lost before the request was acknowledged")
+		}
+		return status, nil
+	case <-time.After(this.Timeout):
+		return 0, errors.New("spike: timed out waiting for the request to be acknowledged")
+	}
+}
+
+// Removes the waiter of a request which could not be sent, unless the queue
+// was already emptied by a reconnection.
+func (this *HubClient) forget(queue *[]chan int16, waiter chan int16) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	for i, queued := range *queue {
+		if queued == waiter {
+			*queue = append((*queue)[:i:i], (*queue)[i+1:]...)
+			return
+		}
+	}
+}
+
+// Pops the first waiter of the queue and hands it the status.
+func (this *HubClient) acknowledge(queue *[]chan int16, status int16) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	if len(*queue) == 0 {
+		return
+	}
+
+	waiter := (*queue)[0]
+	*queue = (*queue)[1:]
+	waiter <- status
+}
+
+// Routes the messages received on the channel until it disconnects.
+func (this *HubClient) dispatch(channel *Channel) {
+	for {
+		select {
+		case msg := <-channel.OnHubSubscribe:
+			this.acknowledge(&this.subscribes, msg.Status)
+		case msg := <-channel.OnHubUnsubscribe:
+			this.acknowledge(&this.unsubscribes, msg.Status)
+		case msg := <-channel.OnHubPublish:
+			this.acknowledge(&this.publishes, msg.Status)
+		case msg := <-channel.OnHubEvent:
+			this.guard.Lock()
+			subscription, ok := this.subscriptions[msg.HubName]
+			this.guard.Unlock()
+			if !ok {
+				continue
+			}
+
+			if subscription.handler != nil {
+				subscription.handler(msg)
+				continue
+			}
+			select {
+			case subscription.events <- msg:
+			default:
+			}
+		case <-channel.OnDisconnect:
+			this.reconnect()
+			return
+		}
+	}
+}
+
+// Fails every pending request, then reconnects and subscribes again to every active hub.
+func (this *HubClient) reconnect() {
+	this.guard.Lock()
+	for _, queue := range []*[]chan int16{&this.subscribes, &this.unsubscribes, &this.publishes} {
+		for _, waiter := range *queue {
+			close(waiter)
+		}
+		*queue = nil
+	}
+	this.guard.Unlock()
+
+	for {
+		this.guard.Lock()
+		closed := this.closed
+		this.guard.Unlock()
+		if closed {
+			return
+		}
+
+		time.Sleep(this.ReconnectDelay)
+		if err := this.dial(); err != nil {
+			this.fail(err)
+			continue
+		}
+
+		select {
+		case this.OnReconnect <- struct{}{}:
+		default:
+		}
+		break
+	}
+
+	// Subscribe again, in the background since the dispatch loop acknowledges them
+	this.guard.Lock()
+	subscriptions := make(map[string]*hubSubscription, len(this.subscriptions))
+	for hub, subscription := range this.subscriptions {
+		subscriptions[hub] = subscription
+	}
+	this.guard.Unlock()
+
+	for hub, subscription := range subscriptions {
+		go func(hub string, subscription *hubSubscription) {
+			if err := this.subscribe(hub, subscription); err != nil {
+				this.fail(err)
+			}
+		}(hub, subscription)
+	}
+}
+
+// Reports a background error, dropping it if nobody listens.
+func (this *HubClient) fail(err error) {
+	select {
+	case this.OnError <- err:
+	default:
+	}
+}
@@ -0,0 +1,127 @@
+package spike
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Returns a dialer which hands out one end of a new net.Pipe on every dial, and
+// the channel on which the server ends are delivered.
+func pipeDialer(t *testing.T) (Dialer, <-chan *pipeServer) {
+	servers := make(chan *pipeServer, 4)
+	dialer := DialerFunc(func(address string) (io.ReadWriteCloser, error) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		servers <- &pipeServer{t, server}
+		return client, nil
+	})
+	return dialer, servers
+}
+
+// Waits for the client to dial the next server end.
+func nextServer(t *testing.T, servers <-chan *pipeServer) *pipeServer {
+	select {
+	case server := <-servers:
+		return server
+	case <-time.After(2 * time.Second):
+		t.Fatal("the client did not dial")
+		return nil
+	}
+}
+
+// Reads a subscription request, fails the test unless it is for the hub, and acknowledges it.
+func acceptSubscribe(server *pipeServer, hub string) {
+	reader := server.expect(hubSubscribeKey)
+	reader.Decompress()
+	if got, _ := reader.ReadString(); got != hub {
+		server.t.Errorf("subscribed to %q, want %q", got, hub)
+	}
+
+	status := NewPacketWriter()
+	status.WriteInt16(int16(HubStatusSuccess))
+	server.write(hubSubscribeKey, status)
+}
+
+func TestHubClientSubscribesAgainAfterAReconnect(t *testing.T) {
+	dialer, servers := pipeDialer(t)
+	client := NewHubClient("pipe", 0)
+	client.Dialer = dialer
+	client.ReconnectDelay = 10 * time.Millisecond
+	client.Timeout = 2 * time.Second
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The pipe blocks every write until the server reads it
+	server := nextServer(t, servers)
+	subscribed := make(chan error, 1)
+	var events <-chan *HubEventInform
+	go func() {
+		var err error
+		events, err = client.Subscribe("news", "key")
+		subscribed <- err
+	}()
+	acceptSubscribe(server, "news")
+	if err := <-subscribed; err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the connection, the client dials again and subscribes to the hub
+	server.conn.Close()
+	server = nextServer(t, servers)
+	acceptSubscribe(server, "news")
+	select {
+	case <-client.OnReconnect:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the reconnection was not notified")
+	}
+
+	event := NewPacketWriter()
+	event.WriteString("news")
+	event.WriteString("hello")
+	event.WriteDateTime(time.Now())
+	event.Compress()
+	server.write(hubEventKey, event)
+	select {
+	case msg := <-events:
+		if msg.Message != "hello" {
+			t.Errorf("received %q, want hello", msg.Message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the event was not routed after the reconnection")
+	}
+
+	select {
+	case err := <-client.OnError:
+		t.Errorf("the reconnection failed: %v", err)
+	default:
+	}
+}
+
+func TestHubClientFailsRequestsOnAClosedChannel(t *testing.T) {
+	dialer, servers := pipeDialer(t)
+	client := NewHubClient("pipe", 0)
+	client.Dialer = dialer
+	client.ReconnectDelay = time.Hour
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server := nextServer(t, servers)
+	server.conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Channel().IsOpen() {
+		if time.Now().After(deadline) {
+			t.Fatal("the channel did not close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := client.Publish("news", "key", "hello"); err == nil {
+		t.Error("published on a closed channel")
+	}
+}
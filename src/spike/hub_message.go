@@ -0,0 +1,157 @@
+package spike
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Represents an encoding of structured values into hub messages.
+type HubCodec interface {
+	// Gets the name of the codec, which prefixes every encoded message.
+	Name() string
+
+	// Encodes the value into the body of a hub message.
+	Encode(value interface{}) (string, error)
+
+	// Decodes the body of a hub message into the value pointed to.
+	Decode(body string, value interface{}) error
+}
+
+var (
+	// Encodes the values as JSON documents.
+	JsonCodec HubCodec = jsonCodec{}
+
+	// Encodes the values with the dynamic type encoding of the packets, in base64.
+	DynamicCodec HubCodec = dynamicCodec{}
+
+	// Encodes raw binary values, in base64.
+	BinaryCodec HubCodec = binaryCodec{}
+)
+
+// The codecs which are recognized when decoding a hub message.
+var hubCodecs = map[string]HubCodec{
+	JsonCodec.Name():    JsonCodec,
+	DynamicCodec.Name(): DynamicCodec,
+	BinaryCodec.Name():  BinaryCodec,
+}
+
+// Encodes the value into a hub message, prefixed by the name of the codec so the
+// subscribers can decode it without knowing in advance how it was encoded.
+func EncodeHubMessage(codec HubCodec, value interface{}) (string, error) {
+	body, err := codec.Encode(value)
+	if err != nil {
+		return "", err
+	}
+	return codec.Name() + ":" + body, nil
+}
+
+// Decodes a hub message produced by EncodeHubMessage into the value pointed to.
+func DecodeHubMessage(message string, value interface{}) error {
+	i := strings.Index(message, ":")
+	if i < 0 {
+		return errors.New("spike.DecodeHubMessage: message is not encoded")
+	}
+
+	codec, ok := hubCodecs[message[:i]]
+	if !ok {
+		return errors.New("spike.DecodeHubMessage: unknown codec '" + message[:i] + "'")
+	}
+	return codec.Decode(message[i+1:], value)
+}
+
+// Encodes the value and publishes it to the hub.
+func (this *Channel) HubPublishValue(HubName string, PublishKey string, codec HubCodec, value interface{}) error {
+	message, err := EncodeHubMessage(codec, value)
+	if err != nil {
+		return err
+	}
+
+	return this.HubPublish(HubName, PublishKey, message)
+}
+
+// Encodes the value, publishes it to the hub and waits for the acknowledgement.
+func (this *HubClient) PublishValue(hub string, key string, codec HubCodec, value interface{}) error {
+	message, err := EncodeHubMessage(codec, value)
+	if err != nil {
+		return err
+	}
+	return this.Publish(hub, key, message)
+}
+
+// Decodes the message of the event into the value pointed to.
+func (this *HubEventInform) Decode(value interface{}) error {
+	return DecodeHubMessage(this.Message, value)
+}
+
+// ------------------ Codecs ------------------------
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Encode(value interface{}) (string, error) {
+	body, err := json.Marshal(value)
+	return string(body), err
+}
+
+func (jsonCodec) Decode(body string, value interface{}) error {
+	return json.Unmarshal([]byte(body), value)
+}
+
+type dynamicCodec struct{}
+
+func (dynamicCodec) Name() string {
+	return "dyn"
+}
+
+func (dynamicCodec) Encode(value interface{}) (string, error) {
+	writer := NewPacketWriter()
+	if err := writer.WriteDynamicType(value); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(writer.buffer.Bytes()), nil
+}
+
+func (dynamicCodec) Decode(body string, value interface{}) error {
+	target, ok := value.(*interface{})
+	if !ok {
+		return errors.New("spike.dynamicCodec: value must be a *interface{}")
+	}
+
+	buffer, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return err
+	}
+
+	*target, err = NewPacketReader(buffer).ReadDynamicType()
+	return err
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "bin"
+}
+
+func (binaryCodec) Encode(value interface{}) (string, error) {
+	buffer, ok := value.([]byte)
+	if !ok {
+		return "", errors.New("spike.binaryCodec: value must be a []byte")
+	}
+	return base64.StdEncoding.EncodeToString(buffer), nil
+}
+
+func (binaryCodec) Decode(body string, value interface{}) error {
+	target, ok := value.(*[]byte)
+	if !ok {
+		return errors.New("spike.binaryCodec: value must be a *[]byte")
+	}
+
+	buffer, err := base64.StdEncoding.DecodeString(body)
+	*target = buffer
+	return err
+}
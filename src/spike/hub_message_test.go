@@ -0,0 +1,93 @@
+package spike
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestJsonHubMessageRoundTrip(t *testing.T) {
+	type reading struct {
+		Sensor string
+		Value  float64
+	}
+
+	message, err := EncodeHubMessage(JsonCodec, reading{"t1", 21.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(message, "json:{") {
+		t.Errorf("encoded as %q", message)
+	}
+
+	var got reading
+	if err := (&HubEventInform{Message: message}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Sensor != "t1" || got.Value != 21.5 {
+		t.Errorf("decoded %+v", got)
+	}
+}
+
+func TestDynamicHubMessageRoundTrip(t *testing.T) {
+	for _, value := range []interface{}{true, int16(-3), int32(42), int64(1 << 40), uint32(7), 2.5, "hello"} {
+		message, err := EncodeHubMessage(DynamicCodec, value)
+		if err != nil {
+			t.Fatalf("%T: %v", value, err)
+		}
+		if !strings.HasPrefix(message, "dyn:") {
+			t.Errorf("%T encoded as %q", value, message)
+		}
+
+		var got interface{}
+		if err := DecodeHubMessage(message, &got); err != nil {
+			t.Fatalf("%T: %v", value, err)
+		}
+		if got != value {
+			t.Errorf("%v (%T) came back as %v (%T)", value, value, got, got)
+		}
+	}
+}
+
+func TestBinaryHubMessageRoundTrip(t *testing.T) {
+	value := []byte{0, 1, 2, 0xFF, ':'}
+	message, err := EncodeHubMessage(BinaryCodec, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	if err := DecodeHubMessage(message, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("decoded %v, want %v", got, value)
+	}
+}
+
+func TestHubMessageErrors(t *testing.T) {
+	var value interface{}
+	if err := DecodeHubMessage("xml:<a/>", &value); err == nil || !strings.Contains(err.Error(), "unknown codec 'xml'") {
+		t.Errorf("an unknown codec returned %v", err)
+	}
+	if err := DecodeHubMessage("hello", &value); err == nil || !strings.Contains(err.Error(), "not encoded") {
+		t.Errorf("an unencoded message returned %v", err)
+	}
+
+	// The dynamic encoding only knows the primitive types of the protocol
+	if _, err := EncodeHubMessage(DynamicCodec, struct{ Name string }{"a"}); err == nil {
+		t.Error("a struct was encoded with the dynamic codec")
+	}
+	if _, err := EncodeHubMessage(DynamicCodec, 42); err == nil {
+		t.Error("an int of no fixed size was encoded with the dynamic codec")
+	}
+
+	// The values decoded must be of the type the codec produces
+	var text string
+	if err := DecodeHubMessage("dyn:AQ==", &text); err == nil {
+		t.Error("the dynamic codec decoded into a *string")
+	}
+	if _, err := EncodeHubMessage(BinaryCodec, "text"); err == nil {
+		t.Error("the binary codec encoded a string")
+	}
+}
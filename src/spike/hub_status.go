@@ -0,0 +1,68 @@
+package spike
+
+import (
+	"fmt"
+)
+
+// Represents the status returned by the server for a hub request. The packet
+// definitions this package is generated from only carry the status as an int16
+// and define zero as a success. They define no other value, so no other status
+// is named here: a name without a definition from the server would only be
+// another guess. Callers should treat any other status as a refusal, which
+// HubError reports by its number.
+type HubStatus int16
+
+// The request has succeeded, the only status defined by the protocol.
+const HubStatusSuccess HubStatus = 0
+
+// Returns a readable description of the status.
+func (this HubStatus) String() string {
+	if this == HubStatusSuccess {
+		return "success"
+	}
+	return fmt.Sprintf("status %d", int16(this))
+}
+
+// Returns an error for the hub operation if the status is not a success, or nil otherwise.
+func (this HubStatus) Err(operation HubOperation, hub string) error {
+	if this == HubStatusSuccess {
+		return nil
+	}
+	return &HubError{Operation: operation, Hub: hub, Status: this}
+}
+
+// Represents an operation performed on a hub.
+type HubOperation string
+
+const (
+	HubOperationSubscribe   HubOperation = "subscribe to"
+	HubOperationUnsubscribe HubOperation = "unsubscribe from"
+	HubOperationPublish     HubOperation = "publish to"
+)
+
+// Represents a hub request which was refused by the server.
+type HubError struct {
+	Operation HubOperation
+	Hub       string
+	Status    HubStatus
+}
+
+// Returns the error message.
+func (this *HubError) Error() string {
+	return fmt.Sprintf("spike: unable to %s hub '%s': %s", this.Operation, this.Hub, this.Status)
+}
+
+// Returns an error if the subscription to the hub has failed, or nil otherwise.
+func (this *HubSubscribeInform) Err(hub string) error {
+	return HubStatus(this.Status).Err(HubOperationSubscribe, hub)
+}
+
+// Returns an error if the unsubscription from the hub has failed, or nil otherwise.
+func (this *HubUnsubscribeInform) Err(hub string) error {
+	return HubStatus(this.Status).Err(HubOperationUnsubscribe, hub)
+}
+
+// Returns an error if the publication to the hub has failed, or nil otherwise.
+func (this *HubPublishInform) Err(hub string) error {
+	return HubStatus(this.Status).Err(HubOperationPublish, hub)
+}
@@ -0,0 +1,24 @@
+package spike
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHubStatusErr(t *testing.T) {
+	if err := (&HubSubscribeInform{Status: 0}).Err("news"); err != nil {
+		t.Errorf("a success returned %v", err)
+	}
+
+	err := (&HubPublishInform{Status: 2}).Err("news")
+	var hubErr *HubError
+	if !errors.As(err, &hubErr) {
+		t.Fatalf("a failure returned %v, want a *HubError", err)
+	}
+	if hubErr.Operation != HubOperationPublish || hubErr.Hub != "news" || hubErr.Status != 2 {
+		t.Errorf("unexpected error %+v", hubErr)
+	}
+	if got, want := err.Error(), "spike: unable to publish to hub 'news': status 2"; got != want {
+		t.Errorf("the message is %q, want %q", got, want)
+	}
+}
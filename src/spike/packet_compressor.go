@@ -188,6 +188,30 @@ func Compress(input []byte) (output []byte) {
 
 }
 
+// CompressLiterals encodes the input in the LZF format using literal references
+// only. It is used when the input is too short or does not compress, in which
+// case Compress returns nil.
+func CompressLiterals(input []byte) (output []byte) {
+	inputLength := uint32(len(input))
+	output = make([]byte, 4, 4+inputLength+inputLength/maxLiteral+1)
+	output[0] = byte((inputLength >> 24) & 255)
+	output[1] = byte((inputLength >> 16) & 255)
+	output[2] = byte((inputLength >> 8) & 255)
+	output[3] = byte((inputLength >> 0) & 255)
+
+	for iidx := uint32(0); iidx < inputLength; iidx += maxLiteral {
+		end := iidx + maxLiteral
+		if end > inputLength {
+			end = inputLength
+		}
+
+		output = append(output, byte(end-iidx-1))
+		output = append(output, input[iidx:end]...)
+	}
+
+	return output
+}
+
 func Decompress(input []byte) (output []byte) {
 
 	inputLength := uint32(len(input))
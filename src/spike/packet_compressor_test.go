@@ -0,0 +1,38 @@
+package spike
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressLiteralsRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, size := range []int{0, 1, int(maxLiteral) - 1, int(maxLiteral), int(maxLiteral) + 1, 100, 4096} {
+		input := make([]byte, size)
+		random.Read(input)
+
+		output := Decompress(CompressLiterals(input))
+		if !bytes.Equal(output, input) && !(size == 0 && len(output) == 0) {
+			t.Errorf("%d bytes came back as %d different bytes", size, len(output))
+		}
+	}
+}
+
+func TestCompressedPacketsRoundTrip(t *testing.T) {
+	// Short bodies do not compress and go out as literals, long ones compress
+	for _, message := range []string{"a", "hello", string(bytes.Repeat([]byte("sping "), 200))} {
+		writer := NewPacketWriter()
+		writer.WriteString("news")
+		writer.WriteString(message)
+		writer.Compress()
+
+		reader := NewPacketReader(writer.Bytes())
+		reader.Decompress()
+		hub, _ := reader.ReadString()
+		got, _ := reader.ReadString()
+		if hub != "news" || got != message {
+			t.Errorf("read %q and %q back, want news and %q", hub, got, message)
+		}
+	}
+}
@@ -22,7 +22,17 @@ func NewPacketWriter() *PacketWriter {
 
 // Compresses the packet body
 func (this *PacketWriter) Compress(){
-	this.buffer = bytes.NewBuffer(Compress(this.buffer.Bytes()))
+	compressed := Compress(this.buffer.Bytes())
+	if compressed == nil {
+		// Short or incompressible bodies are still sent in the LZF format, as literals
+		compressed = CompressLiterals(this.buffer.Bytes())
+	}
+	this.buffer = bytes.NewBuffer(compressed)
+}
+
+// Returns the packet body written so far
+func (this *PacketWriter) Bytes() []byte {
+	return this.buffer.Bytes()
 }
 
 
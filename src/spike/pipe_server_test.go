@@ -0,0 +1,80 @@
+package spike
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Represents the server end of a net.Pipe, which reads the packets a Channel
+// sends and writes packets back, as a Spike Engine service would.
+type pipeServer struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// Opens a Channel over a net.Pipe and returns it along with the server end.
+func newPipeChannel(t *testing.T) (*Channel, *pipeServer) {
+	client, server := net.Pipe()
+	channel := NewChannel(client, 0)
+	t.Cleanup(func() {
+		server.Close()
+		channel.Disconnect()
+	})
+	return channel, &pipeServer{t, server}
+}
+
+// Reads the next packet and returns its key and a reader over its body.
+func (this *pipeServer) read() (uint32, *PacketReader) {
+	this.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(this.conn, header); err != nil {
+		this.t.Fatal("reading the header:", err)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint32(header[0:4])-4)
+	if _, err := io.ReadFull(this.conn, body); err != nil {
+		this.t.Fatal("reading the body:", err)
+	}
+	return binary.BigEndian.Uint32(header[4:8]), NewPacketReader(body)
+}
+
+// Reads the next packet and fails the test unless it has the key.
+func (this *pipeServer) expect(key uint32) *PacketReader {
+	got, reader := this.read()
+	if got != key {
+		this.t.Fatalf("received the packet %#x, want %#x", got, key)
+	}
+	return reader
+}
+
+// Writes a packet with the key and the body written by the writer.
+func (this *pipeServer) write(key uint32, writer *PacketWriter) {
+	if err := this.writeRaw(frame(key, writer.Bytes())); err != nil {
+		this.t.Fatal("writing the packet:", err)
+	}
+}
+
+// Writes raw bytes, which may hold several packets or part of one.
+func (this *pipeServer) writeRaw(data []byte) error {
+	this.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	_, err := this.conn.Write(data)
+	return err
+}
+
+// Frames the body with its length and key.
+func frame(key uint32, body []byte) []byte {
+	packet := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(packet[0:4], uint32(len(body)+4))
+	binary.BigEndian.PutUint32(packet[4:8], key)
+	return append(packet, body...)
+}
+
+// Returns a writer holding a single boolean, as the credentials informs do.
+func booleanBody(value bool) *PacketWriter {
+	writer := NewPacketWriter()
+	writer.WriteBoolean(value)
+	return writer
+}
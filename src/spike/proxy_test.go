@@ -0,0 +1,211 @@
+package spike
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a local server which echoes every byte back, so the pings sent to it
+// come back as pongs, and returns its address.
+func startEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// Starts a local stand-in proxy which speaks HTTP CONNECT or SOCKS5, depending on
+// the first byte received, and only lets the user 'u' with the password 'p' in.
+func startStandInProxy(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveProxy(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// Negotiates the tunnel with the client, then splices it to the target.
+func serveProxy(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+
+	var target string
+	if first[0] == 0x05 {
+		target = socks5Accept(reader, conn)
+	} else {
+		target = httpConnectAccept(reader, conn)
+	}
+	if target == "" {
+		return
+	}
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go io.Copy(upstream, reader)
+	io.Copy(conn, upstream)
+}
+
+// Accepts an HTTP CONNECT request and returns its target, or "" if refused.
+func httpConnectAccept(reader *bufio.Reader, conn net.Conn) string {
+	request, err := http.ReadRequest(reader)
+	if err != nil || request.Method != "CONNECT" {
+		return ""
+	}
+
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("u:p"))
+	if request.Header.Get("Proxy-Authorization") != expected {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return ""
+	}
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return request.Host
+}
+
+// Accepts a SOCKS5 handshake with user name and password and returns its
+// target, or "" if refused.
+func socks5Accept(reader *bufio.Reader, conn net.Conn) string {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(reader, greeting); err != nil {
+		return ""
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return ""
+	}
+	if !strings.Contains(string(methods), "\x02") {
+		conn.Write([]byte{0x05, 0xFF})
+		return ""
+	}
+	conn.Write([]byte{0x05, 0x02})
+
+	// RFC 1929: version, user name and password, each prefixed by its length
+	field := func() string {
+		size, _ := reader.ReadByte()
+		value := make([]byte, size)
+		io.ReadFull(reader, value)
+		return string(value)
+	}
+	reader.ReadByte()
+	if field() != "u" || field() != "p" {
+		conn.Write([]byte{0x01, 0x01})
+		return ""
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	// Only the IPv4 addresses are needed here
+	request := make([]byte, 10)
+	if _, err := io.ReadFull(reader, request); err != nil || request[3] != 0x01 {
+		return ""
+	}
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	port := int(request[8])<<8 | int(request[9])
+	return net.JoinHostPort(net.IP(request[4:8]).String(), strconv.Itoa(port))
+}
+
+// Pings through the dialer and fails the test unless the pong comes back.
+func pingThrough(t *testing.T, dialer TcpDialer, address string) {
+	channel, err := Dial(dialer, address, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Disconnect()
+
+	channel.Ping(42)
+	select {
+	case msg := <-channel.OnPing:
+		if msg.Time != 42 {
+			t.Errorf("received the pong %d, want 42", msg.Time)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no pong came back through the proxy")
+	}
+}
+
+func TestHttpConnectProxy(t *testing.T) {
+	target := startEchoServer(t)
+	proxy := startStandInProxy(t)
+
+	pingThrough(t, TcpDialer{Proxy: &url.URL{Scheme: "http", User: url.UserPassword("u", "p"), Host: proxy}}, target)
+
+	_, err := TcpDialer{Proxy: &url.URL{Scheme: "http", Host: proxy}}.Dial(target)
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("connected without the credentials: %v", err)
+	}
+}
+
+func TestSocks5Proxy(t *testing.T) {
+	target := startEchoServer(t)
+	proxy := startStandInProxy(t)
+
+	pingThrough(t, TcpDialer{Proxy: &url.URL{Scheme: "socks5", User: url.UserPassword("u", "p"), Host: proxy}}, target)
+
+	_, err := TcpDialer{Proxy: &url.URL{Scheme: "socks5", User: url.UserPassword("u", "wrong"), Host: proxy}}.Dial(target)
+	if err == nil {
+		t.Error("connected with the wrong password")
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "")
+	t.Setenv("ALL_PROXY", "socks5://proxy:1080")
+	t.Setenv("NO_PROXY", "localhost, .internal")
+
+	proxy, err := ProxyFromEnvironment("spike.example.com:8002")
+	if err != nil || proxy == nil || proxy.String() != "socks5://proxy:1080" {
+		t.Errorf("the proxy is %v (%v), want socks5://proxy:1080", proxy, err)
+	}
+
+	// HTTPS_PROXY comes first, and a bare address is an HTTP proxy
+	t.Setenv("HTTPS_PROXY", "proxy:3128")
+	if proxy, _ := ProxyFromEnvironment("spike.example.com:8002"); proxy == nil || proxy.String() != "http://proxy:3128" {
+		t.Errorf("the proxy is %v, want http://proxy:3128", proxy)
+	}
+
+	for _, address := range []string{"localhost:8002", "spike.internal:8002", "internal:8002"} {
+		if proxy, _ := ProxyFromEnvironment(address); proxy != nil {
+			t.Errorf("%s goes through %v despite NO_PROXY", address, proxy)
+		}
+	}
+}
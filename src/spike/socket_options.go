@@ -0,0 +1,127 @@
+package spike
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Represents the tuning of the TCP/IP socket a channel runs over, so the probes
+// can match the settings of the actual clients. Use NewSocketOptions to start
+// from the defaults.
+type SocketOptions struct {
+	// Disables Nagle's algorithm when true, which is the default.
+	NoDelay bool
+
+	// Gets or sets the keep-alive period. The system default is kept if zero and
+	// keep-alives are disabled if negative.
+	KeepAlive time.Duration
+
+	// Gets or sets the size of the receive buffer. The system default is kept if zero.
+	ReadBuffer int
+
+	// Gets or sets the size of the send buffer. The system default is kept if zero.
+	WriteBuffer int
+
+	// Gets or sets how long closing waits for the unsent data, rounded up to the
+	// second. The system default is kept if negative, and the data is discarded
+	// if zero.
+	Linger time.Duration
+
+	// Gets or sets how long the transmitted data may remain unacknowledged before
+	// the connection is closed (TCP_USER_TIMEOUT). The system default is kept if zero.
+	UserTimeout time.Duration
+}
+
+// Constructs socket options which keep the defaults.
+func NewSocketOptions() *SocketOptions {
+	options := new(SocketOptions)
+	options.NoDelay = true
+	options.Linger = -1
+	return options
+}
+
+// Applies the options to a TCP/IP connection.
+func (this *SocketOptions) Apply(conn net.Conn) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return errors.New("spike.SocketOptions: not a TCP/IP connection")
+	}
+
+	if err := tcp.SetNoDelay(this.NoDelay); err != nil {
+		return err
+	}
+
+	if this.KeepAlive < 0 {
+		if err := tcp.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if this.KeepAlive > 0 {
+		if err := tcp.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tcp.SetKeepAlivePeriod(this.KeepAlive); err != nil {
+			return err
+		}
+	}
+
+	if this.ReadBuffer > 0 {
+		if err := tcp.SetReadBuffer(this.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if this.WriteBuffer > 0 {
+		if err := tcp.SetWriteBuffer(this.WriteBuffer); err != nil {
+			return err
+		}
+	}
+
+	if this.Linger >= 0 {
+		if err := tcp.SetLinger(lingerSeconds(this.Linger)); err != nil {
+			return err
+		}
+	}
+
+	if this.UserTimeout > 0 {
+		return setUserTimeout(tcp, this.UserTimeout)
+	}
+	return nil
+}
+
+// Converts the linger time to the whole seconds of SO_LINGER, rounding up so that
+// a sub-second time still waits rather than discarding the data with a reset.
+func lingerSeconds(linger time.Duration) int {
+	return int((linger + time.Second - 1) / time.Second)
+}
+
+// Describes the options which differ from the defaults.
+func (this *SocketOptions) String() string {
+	settings := make([]string, 0)
+	if !this.NoDelay {
+		settings = append(settings, "nodelay off")
+	}
+	if this.KeepAlive < 0 {
+		settings = append(settings, "keepalive off")
+	} else if this.KeepAlive > 0 {
+		settings = append(settings, "keepalive "+this.KeepAlive.String())
+	}
+	if this.ReadBuffer > 0 {
+		settings = append(settings, fmt.Sprintf("read buffer %d", this.ReadBuffer))
+	}
+	if this.WriteBuffer > 0 {
+		settings = append(settings, fmt.Sprintf("write buffer %d", this.WriteBuffer))
+	}
+	if this.Linger >= 0 {
+		settings = append(settings, "linger "+this.Linger.String())
+	}
+	if this.UserTimeout > 0 {
+		settings = append(settings, "user timeout "+this.UserTimeout.String())
+	}
+
+	if len(settings) == 0 {
+		return "defaults"
+	}
+	return strings.Join(settings, ", ")
+}
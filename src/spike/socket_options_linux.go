@@ -0,0 +1,26 @@
+package spike
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// The TCP_USER_TIMEOUT socket option, which the syscall package does not define.
+const tcpUserTimeout = 0x12
+
+// Sets how long the transmitted data may remain unacknowledged, in milliseconds.
+func setUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var result error
+	if err := raw.Control(func(fd uintptr) {
+		result = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(timeout/time.Millisecond))
+	}); err != nil {
+		return err
+	}
+	return result
+}
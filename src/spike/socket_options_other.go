@@ -0,0 +1,14 @@
+//go:build !linux
+
+package spike
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// The TCP user timeout is only supported on Linux.
+func setUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	return errors.New("spike.SocketOptions: the user timeout is not supported on this platform")
+}
@@ -0,0 +1,21 @@
+package spike
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLingerSecondsRoundsUp(t *testing.T) {
+	cases := map[time.Duration]int{
+		0:                       0,
+		500 * time.Millisecond:  1,
+		time.Second:             1,
+		1500 * time.Millisecond: 2,
+		5 * time.Second:         5,
+	}
+	for linger, want := range cases {
+		if got := lingerSeconds(linger); got != want {
+			t.Errorf("lingering %v is %d seconds, want %d", linger, got, want)
+		}
+	}
+}
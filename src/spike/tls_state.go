@@ -0,0 +1,20 @@
+package spike
+
+import (
+	"crypto/tls"
+)
+
+// Gets the state of the TLS connection the channel runs over, such as the
+// negotiated version and the peer certificates. Returns false if the connection
+// is not encrypted.
+func (this *Channel) TLSConnectionState() (tls.ConnectionState, bool) {
+	switch conn := this.conn.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState(), true
+	case *webSocketConn:
+		if secure, ok := conn.ws.UnderlyingConn().(*tls.Conn); ok {
+			return secure.ConnectionState(), true
+		}
+	}
+	return tls.ConnectionState{}, false
+}
@@ -0,0 +1,162 @@
+package spike
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Represents a WebSocket Channel to a Spike Engine server. It carries the same
+// packets as the TcpChannel, one packet per binary message, and offers the same
+// requests and events.
+type WebSocketChannel struct {
+	Channel
+}
+
+// Connects to the WebSocket URL, such as 'ws://host:port/' or 'wss://host/'. The
+// TLS configuration is only used for 'wss' and can be nil.
+func (this *WebSocketChannel) Connect(url string, bufferSize int, config *tls.Config) (net.Conn, error) {
+	conn, err := WebSocketDialer{TLSConfig: config, BufferSize: bufferSize}.DialConn(url)
+	if err != nil {
+		return nil, err
+	}
+
+	this.open(conn, bufferSize)
+	return conn, nil
+}
+
+// Represents a Dialer which connects over WebSocket.
+type WebSocketDialer struct {
+	// Gets or sets the TLS configuration, used for 'wss' URLs. Can be nil.
+	TLSConfig *tls.Config
+
+	// Gets or sets the size of the read and write buffers, 8K if zero.
+	BufferSize int
+
+	// Gets or sets the HTTP CONNECT or SOCKS5 proxy to connect through. Direct if nil.
+	Proxy *url.URL
+
+	// Gets or sets the dialer which opens the TCP/IP connections, for instance to
+	// bind a local address. A zero dialer is used if nil.
+	Dialer *net.Dialer
+
+	// Gets or sets the network: 'tcp4' or 'tcp6' to force the address family, or
+	// 'tcp' if empty.
+	Network string
+
+	// Gets or sets the tuning of the socket. The system defaults are kept if nil.
+	Socket *SocketOptions
+}
+
+// Connects to the WebSocket URL, such as 'ws://host:port/' or 'wss://host/'.
+func (this WebSocketDialer) Dial(url string) (io.ReadWriteCloser, error) {
+	return this.DialConn(url)
+}
+
+// Connects to the WebSocket URL and returns the connection, seen as a stream.
+func (this WebSocketDialer) DialConn(address string) (net.Conn, error) {
+	// Default is 8K
+	bufferSize := this.BufferSize
+	if bufferSize == 0 {
+		bufferSize = 8192
+	}
+
+	dial := tcpDialFunc(this.Dialer, this.Network, this.Socket)
+	dialer := &websocket.Dialer{
+		NetDial: func(_ string, address string) (net.Conn, error) {
+			return dial(address)
+		},
+		TLSClientConfig:  this.TLSConfig,
+		HandshakeTimeout: 30 * time.Second,
+		ReadBufferSize:   bufferSize,
+		WriteBufferSize:  bufferSize,
+	}
+
+	if this.Proxy != nil {
+		dialer.Proxy = http.ProxyURL(this.Proxy)
+	}
+
+	ws, _, err := dialer.Dial(address, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &webSocketConn{ws: ws, guard: new(sync.Mutex)}, nil
+}
+
+// Represents a WebSocket connection seen as a stream, so the channel can read
+// and write the packets exactly as it does over TCP/IP.
+type webSocketConn struct {
+	ws     *websocket.Conn
+	guard  *sync.Mutex
+	reader io.Reader
+}
+
+// Reads from the current message, moving to the next one once it is consumed.
+func (this *webSocketConn) Read(b []byte) (int, error) {
+	for {
+		if this.reader == nil {
+			_, reader, err := this.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			this.reader = reader
+		}
+
+		n, err := this.reader.Read(b)
+		if err == io.EOF {
+			this.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Writes the bytes as a single binary message.
+func (this *webSocketConn) Write(b []byte) (int, error) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	if err := this.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Closes the connection, after telling the server.
+func (this *webSocketConn) Close() error {
+	this.guard.Lock()
+	this.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	this.guard.Unlock()
+	return this.ws.Close()
+}
+
+func (this *webSocketConn) LocalAddr() net.Addr {
+	return this.ws.LocalAddr()
+}
+
+func (this *webSocketConn) RemoteAddr() net.Addr {
+	return this.ws.RemoteAddr()
+}
+
+func (this *webSocketConn) SetDeadline(t time.Time) error {
+	if err := this.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return this.ws.SetWriteDeadline(t)
+}
+
+func (this *webSocketConn) SetReadDeadline(t time.Time) error {
+	return this.ws.SetReadDeadline(t)
+}
+
+func (this *webSocketConn) SetWriteDeadline(t time.Time) error {
+	return this.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,129 @@
+package spike
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Starts a local WebSocket server which hands every upgraded connection to the
+// handler, and returns its ws:// URL.
+func startWebSocketServer(t *testing.T, handler func(*websocket.Conn)) string {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		handler(ws)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+}
+
+// Answers the pings with two pongs each, split across messages so no message
+// holds exactly one packet.
+func serveSplitPongs(t *testing.T, ws *websocket.Conn) {
+	for {
+		kind, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if kind != websocket.BinaryMessage || int(binary.BigEndian.Uint32(message[0:4]))+4 != len(message) {
+			t.Errorf("received a message of type %d which is not exactly one packet: %v", kind, message)
+			return
+		}
+		if binary.BigEndian.Uint32(message[4:8]) != pingKey {
+			continue
+		}
+
+		pongs := append(frame(pingKey, message[8:]), frame(pingKey, message[8:])...)
+		for _, piece := range [][]byte{pongs[:5], pongs[5:14], pongs[14:]} {
+			if err := ws.WriteMessage(websocket.BinaryMessage, piece); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestWebSocketChannelPings(t *testing.T) {
+	address := startWebSocketServer(t, func(ws *websocket.Conn) { serveSplitPongs(t, ws) })
+
+	channel := new(WebSocketChannel)
+	if _, err := channel.Connect(address, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Disconnect()
+
+	if err := channel.Ping(42); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-channel.OnPing:
+			if msg.Time != 42 {
+				t.Errorf("received the pong %d, want 42", msg.Time)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the pong %d was not reassembled", i+1)
+		}
+	}
+}
+
+func TestWebSocketChannelClose(t *testing.T) {
+	closed := make(chan error, 1)
+	address := startWebSocketServer(t, func(ws *websocket.Conn) {
+		_, _, err := ws.ReadMessage()
+		closed <- err
+	})
+
+	channel, err := Dial(WebSocketDialer{}, address, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel.Disconnect()
+
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("the server read %v, want a normal closure", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the server was not told about the closure")
+	}
+	select {
+	case <-channel.OnDisconnect:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the disconnection was not notified")
+	}
+	if err := channel.Ping(1); err == nil {
+		t.Error("a packet was sent on a closed channel")
+	}
+}
+
+func TestWebSocketChannelServerClose(t *testing.T) {
+	address := startWebSocketServer(t, func(ws *websocket.Conn) {
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"))
+	})
+
+	channel, err := Dial(WebSocketDialer{}, address, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Disconnect()
+
+	select {
+	case err := <-channel.OnDisconnect:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("disconnected with %v, want the close of the server", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the closure of the server was not notified")
+	}
+}
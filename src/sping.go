@@ -4,15 +4,18 @@ import(
 	"os"
 	"os/signal"
 	"fmt"
-	"spike"
 	"time"
-	"strings"
 	"strconv"
+	"sync"
 	"github.com/codegangsta/cli"
-	"github.com/montanaflynn/stats"
 )
 
 func main() {
+	newApp().RunAndExitOnError()
+}
+
+// Builds the application along with all its commands.
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "sping"
 	app.Usage = "Pinging and latency measurement utility for Spike Engine 3 powered services."
@@ -27,6 +30,83 @@ func main() {
 			Value: "",
 			Usage: "Sets the output file to write out the latency values instead of calculating them in-memory.",
 		},
+		cli.IntFlag {
+			Name: "size",
+			Value: 0,
+			Usage: "Pads every probe to this many bytes of data. As the Ping packet only carries its time, the padded probes are published to a hub and timed when echoed back.",
+		},
+		cli.StringFlag {
+			Name: "echo-hub",
+			Value: "sping-echo",
+			Usage: "Sets the hub which echoes the padded probes when a size is given.",
+		},
+		cli.StringFlag {
+			Name: "echo-key",
+			Value: "",
+			Usage: "Sets the key used to subscribe and publish to the echo hub.",
+		},
+		cli.StringFlag {
+			Name: "targets",
+			Value: "",
+			Usage: "Reads the list of services to ping from a file, one host per line, in addition to the hosts given as arguments.",
+		},
+		cli.StringFlag {
+			Name: "statsd",
+			Value: "",
+			Usage: "Pushes every latency value and the periodic summaries to a StatsD daemon over UDP, such as '127.0.0.1:8125'.",
+		},
+		cli.StringFlag {
+			Name: "statsd-prefix",
+			Value: "sping",
+			Usage: "Sets the prefix of the metric names pushed to StatsD.",
+		},
+		cli.StringFlag {
+			Name: "influx",
+			Value: "",
+			Usage: "Pushes every latency value and the periodic summaries using the InfluxDB line protocol. Accepts 'udp://host:port', 'http://host:port/write?db=name' or 'file:///path/to/file'.",
+		},
+		cli.StringFlag {
+			Name: "probe",
+			Value: "ping",
+			Usage: "Sets the probe name the pushed metrics are tagged with.",
+		},
+		cli.BoolFlag {
+			Name: "connect-only",
+			Usage: "Repeatedly connects, pings once and disconnects, then prints the distribution of the time spent resolving, connecting, in the TLS handshake and until the first ping.",
+		},
+		cli.IntFlag {
+			Name: "count, c",
+			Value: 10,
+			Usage: "Sets the number of connections made with --connect-only.",
+		},
+		cli.IntFlag {
+			Name: "warn-cert-days",
+			Value: 0,
+			Usage: "Exits with 1 if the TLS certificate chain of a service expires within this many days. Disabled if zero.",
+		},
+		cli.IntFlag {
+			Name: "crit-cert-days",
+			Value: 0,
+			Usage: "Exits with 2 if the TLS certificate chain of a service expires within this many days, or has expired. Disabled if zero.",
+		},
+		cli.StringFlag {
+			Name: "summary-interval",
+			Value: "10s",
+			Usage: "Sets the interval between two summaries pushed to StatsD or InfluxDB.",
+		},
+	}
+	app.Flags = append(app.Flags, credentialsFlags...)
+	app.Flags = append(app.Flags, dialFlags...)
+	app.Commands = []cli.Command {
+		loadCommand(),
+		hubLatencyCommand(),
+		hubCommand(),
+		clockCommand(),
+		oneWayCommand(),
+		dnsCommand(),
+		checkCommand(),
+		runCommand(),
+		daemonCommand(),
 	}
 	app.Action = func(c *cli.Context) {
 		// Recover and print a nicer message
@@ -36,18 +116,27 @@ func main() {
         	}
     	}()
 
-		// Host and port
-		host := "127.0.0.1:8002"
-		if len(c.Args()) > 0 {
-			host = c.Args()[0]
+		// Hosts and ports
+		hosts := []string(c.Args())
+		if c.String("targets") != "" {
+			list, err := readTargets(c.String("targets"))
+			if err != nil {
+				panic(err)
+			}
+			hosts = append(hosts, list...)
 		}
-		if !strings.Contains(host, ":"){
-			host += ":80"
+		if len(hosts) == 0 {
+			hosts = append(hosts, "127.0.0.1:8002")
+		}
+
+		targets := make([]*Target, 0, len(hosts))
+		for _, host := range hosts {
+			targets = append(targets, NewTarget(normalizeHost(host)))
 		}
 
 		// Variables we need
-		var samples []float64
 		var out *os.File
+		var outLock sync.Mutex
 		t0 := time.Now()
 
 		// The interval
@@ -56,6 +145,13 @@ func main() {
 			panic(err)
 		}
 
+		// Only time the connection setup
+		thresholds := certThresholds{c.Int("warn-cert-days"), c.Int("crit-cert-days")}
+		if c.Bool("connect-only") {
+			status := runConnectOnly(targets, c.Int("count"), interval, dialOptionsFromContext(c), credentialsFromContext(c), thresholds)
+			os.Exit(status)
+		}
+
 		// Output file
 		if c.String("out") != "" {
 			out, err = os.OpenFile(c.String("out"), os.O_CREATE|os.O_WRONLY, 0600)
@@ -64,26 +160,122 @@ func main() {
 			}
 		}
 
-		// Connect to the service
-		fmt.Println("Starting pinging a Spike Engine service", host)
-		channel := new(spike.TcpChannel)
-		channel.Connect(host, 8196)
-
-		// Handle pong
-		go func (){
-			for{
-		    	msg := <- channel.OnPing
-		    	rtt := int32(time.Now().Sub(t0) / 1000000) - msg.Time
-		    	fmt.Println("Pinging", host, "with 12 bytes of data:", rtt, "ms.")
-		    	if out != nil {
-		    		if _, err = out.WriteString(strconv.Itoa(int(rtt)) + "\r\n"); err != nil {
-					    panic(err)
+		// Metric outputs
+		outputs := make(Outputs, 0)
+		if c.String("statsd") != "" {
+			o, err := NewStatsdOutput(c.String("statsd"), c.String("statsd-prefix"))
+			if err != nil {
+				panic(err)
+			}
+			outputs = append(outputs, o)
+		}
+		if c.String("influx") != "" {
+			o, err := NewInfluxOutput(c.String("influx"))
+			if err != nil {
+				panic(err)
+			}
+			outputs = append(outputs, o)
+		}
+
+		// Periodic summaries
+		probe := c.String("probe")
+		if len(outputs) > 0 {
+			period, err := time.ParseDuration(c.String("summary-interval"))
+			if err != nil {
+				panic(err)
+			}
+
+			for _, target := range targets {
+				target.Windowed = true
+			}
+			go func(){
+				for range time.Tick(period) {
+					for _, target := range targets {
+						if window := target.Flush(); len(window) > 0 {
+							reportOutput(outputs.Summary(target.Host, probe, summarize(window)))
+						}
 					}
-		    	} else {
-		    		samples = append(samples, float64(rtt))
-		    	}
+				}
+			}()
+		}
+
+		// Padded probes are echoed through a hub
+		var echo *hubEcho
+		size := 12
+		if c.Int("size") > 0 {
+			size = c.Int("size")
+			echo = newHubEcho(c.String("echo-hub"), c.String("echo-key"), c.String("echo-key"), size)
+		}
+
+		// The receive buffer must hold a whole echoed probe
+		bufferSize := 8196
+		if size + 1024 > bufferSize {
+			bufferSize = size + 1024
+		}
+
+		// Connect to the services
+		credentials := credentialsFromContext(c)
+		options := dialOptionsFromContext(c)
+		status := statusOk
+		title := "Ping statistics:"
+		if options.socket != nil {
+			fmt.Println("Socket options:", options.socket)
+			title = "Ping statistics (" + options.socket.String() + "):"
+		}
+		for _, target := range targets {
+			fmt.Println("Starting pinging a Spike Engine service", target.Host)
+			channel, err := connectChannel(target.Host, bufferSize, options)
+			if err != nil {
+				fmt.Println("Unable to connect to", target.Host, err)
+				target.Failed = err
+				status = worstStatus(status, certErrorStatus(target.Host, err))
+				continue
 			}
-		}()
+			status = worstStatus(status, inspectTLS(target.Host, channel, thresholds))
+			if err := authenticate(channel, credentials); err != nil {
+				fmt.Println("Unable to authenticate to", target.Host, err)
+				target.Failed = err
+				channel.Disconnect()
+				continue
+			}
+
+			times := pingTimes(channel)
+			if echo != nil {
+				if times, err = echo.Start(channel); err != nil {
+					fmt.Println("Unable to subscribe to the echo hub of", target.Host, err)
+					target.Failed = err
+					channel.Disconnect()
+					continue
+				}
+			}
+			target.Channel = channel
+
+			// Handle pong
+			go func (target *Target, times <-chan int32){
+				for{
+			    	sent := <- times
+			    	rtt := int32(time.Now().Sub(t0) / 1000000) - sent
+			    	fmt.Println("Pinging", target.Host, "with", size, "bytes of data:", rtt, "ms.")
+			    	if len(outputs) > 0 {
+			    		reportOutput(outputs.Rtt(target.Host, probe, float64(rtt)))
+			    	}
+			    	if out != nil {
+			    		line := strconv.Itoa(int(rtt)) + "\r\n"
+			    		if len(targets) > 1 {
+			    			line = target.Host + " " + line
+			    		}
+
+			    		outLock.Lock()
+			    		_, err := out.WriteString(line)
+			    		outLock.Unlock()
+			    		if err != nil {
+						    panic(err)
+						}
+			    	}
+			    	target.Record(float64(rtt), out == nil)
+				}
+			}(target, times)
+		}
 
 
 		// Hook CTRL+C
@@ -93,53 +285,56 @@ func main() {
 	    	for sig := range schan {
 	        	// sig is a ^C, handle it
 	    		fmt.Println("CTRL-C", sig, "received")
+	    		for _, target := range targets {
+	    			revoke(target.Channel)
+	    		}
+	    		outputs.Close()
 	    		if out != nil {
-	    			os.Exit(0)
+	    			os.Exit(status)
 	    		}
 
-	    		min := stats.Min(samples)
-	    		max := stats.Max(samples)
-	    		mean := stats.Mean(samples)
-	    		median := stats.Median(samples)
-	    		varp := stats.VarP(samples)
-	    		p1 := stats.Percentile(samples, 1)
-	    		p25 := stats.Percentile(samples, 25)
-	    		p50 := stats.Percentile(samples, 50)
-	    		p75 := stats.Percentile(samples, 75)
-	    		p90 := stats.Percentile(samples, 90)
-	    		p95 := stats.Percentile(samples, 95)
-	    		p99 := stats.Percentile(samples, 99)
-
-	    		fmt.Println()
-	    		fmt.Println("Ping statistics:")
-	    		fmt.Println("   Samples:  ", len(samples), "events")
-	    		fmt.Println("   Min:      ", min, "ms.")
-	    		fmt.Println("   Max:      ", max, "ms.")
-	    		fmt.Println("   Mean:     ", mean, "ms.")
-	    		fmt.Println("   Median:   ", median, "ms.")
-	    		fmt.Println("   Variance: ", varp, "ms.")
-	    		fmt.Println()
-	    		fmt.Println("Percentiles:")
-	    		fmt.Println("    1st: ", p1, "ms.")
-	    		fmt.Println("   25th: ", p25, "ms.")
-	    		fmt.Println("   50th: ", p50, "ms.")
-	    		fmt.Println("   75th: ", p75, "ms.")
-	    		fmt.Println("   90th: ", p90, "ms.")
-	    		fmt.Println("   95th: ", p95, "ms.")
-	    		fmt.Println("   99th: ", p99, "ms.")
-	    		os.Exit(0)
+	    		if len(targets) == 1 {
+	    			printSummary(title, summarize(targets[0].Samples()))
+	    		} else {
+	    			printSummaryTable(targets)
+	    		}
+	    		os.Exit(status)
 	    	}
 		}()
 
-		// Ping loop
-		for {
-			// Get the ping start
-			now := int32(time.Now().Sub(t0).Nanoseconds() / 1000000)
-			channel.Ping(now)
-			time.Sleep(interval)
+		// Ping loop, one per connected target
+		connected := 0
+		for _, target := range targets {
+			if target.Channel == nil {
+				continue
+			}
+
+			go func (target *Target){
+				for {
+					// Get the ping start, and stop pinging a server which dropped the connection
+					now := int32(time.Now().Sub(t0).Nanoseconds() / 1000000)
+					var err error
+					if echo != nil {
+						err = echo.Send(target.Channel, now)
+					} else {
+						err = target.Channel.Ping(now)
+					}
+					if err != nil {
+						fmt.Println("Lost the connection to", target.Host, err)
+						target.Failed = err
+						return
+					}
+					time.Sleep(interval)
+				}
+			}(target)
+			connected++
+		}
+
+		if connected == 0 {
+			panic("unable to connect to any of the services")
 		}
+		select {}
 	}
 
-	// Run the application
-	app.RunAndExitOnError()
+	return app
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"spike"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// The exit codes which report the state of a service, following the conventions
+// of the Nagios plugins.
+const (
+	statusOk       = 0
+	statusWarning  = 1
+	statusCritical = 2
+	statusUnknown  = 3
+)
+
+// The labels of the statuses, in the order of their exit codes.
+var statusLabels = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// Represents the round-trip time and packet loss thresholds of a check.
+type checkThreshold struct {
+	rtt  float64
+	loss float64
+}
+
+// Returns the command which runs a bounded probe as a Nagios/Icinga plugin.
+func checkCommand() cli.Command {
+	return cli.Command{
+		Name:      "check",
+		Usage:     "Sends a few pings and prints a single Nagios-format status line with performance data. Exits with 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN).",
+		ArgsUsage: "[host]",
+		Flags: append([]cli.Flag{
+			cli.IntFlag{
+				Name:  "count, p",
+				Value: 5,
+				Usage: "Sets the number of pings to send.",
+			},
+			cli.StringFlag{
+				Name:  "interval",
+				Value: "200ms",
+				Usage: "Sets the interval between two pings.",
+			},
+			cli.StringFlag{
+				Name:  "timeout, t",
+				Value: "10s",
+				Usage: "Sets how long the whole check may take, connecting included. The unanswered pings are lost.",
+			},
+			cli.StringFlag{
+				Name:  "warning, w",
+				Value: "100,20%",
+				Usage: "Sets the warning thresholds on the average round-trip time in milliseconds and the packet loss, as 'RTT,LOSS%'.",
+			},
+			cli.StringFlag{
+				Name:  "critical, c",
+				Value: "500,60%",
+				Usage: "Sets the critical thresholds on the average round-trip time in milliseconds and the packet loss, as 'RTT,LOSS%'.",
+			},
+			cli.IntFlag{
+				Name:  "warn-cert-days",
+				Value: 0,
+				Usage: "Warns if the TLS certificate chain expires within this many days. Disabled if zero.",
+			},
+			cli.IntFlag{
+				Name:  "crit-cert-days",
+				Value: 0,
+				Usage: "Is critical if the TLS certificate chain expires within this many days. Disabled if zero.",
+			},
+		}, append(credentialsFlags, dialFlags...)...),
+		Action: func(c *cli.Context) {
+			// Any failure to run the check is unknown, not a warning
+			defer func() {
+				if r := recover(); r != nil {
+					exitCheck(statusUnknown, fmt.Sprint(r), "")
+				}
+			}()
+
+			host := "127.0.0.1:8002"
+			if len(c.Args()) > 0 {
+				host = c.Args()[0]
+			}
+			host = normalizeHost(host)
+
+			count := c.Int("count")
+			if count < 1 {
+				panic("the count must be at least 1")
+			}
+			interval, err := time.ParseDuration(c.String("interval"))
+			if err != nil {
+				panic(err)
+			}
+			timeout, err := time.ParseDuration(c.String("timeout"))
+			if err != nil {
+				panic(err)
+			}
+			warning := parseCheckThreshold(c.String("warning"))
+			critical := parseCheckThreshold(c.String("critical"))
+			thresholds := certThresholds{c.Int("warn-cert-days"), c.Int("crit-cert-days")}
+			credentials := credentialsFromContext(c)
+			deadline := time.After(timeout)
+
+			// Unreadable credentials are a problem of the check, not of the service.
+			// They are read once, so a password command does not run twice.
+			if credentials != nil {
+				supplied, err := credentials.Credentials()
+				if err != nil {
+					panic(err)
+				}
+				credentials = spike.NewStaticCredentialProvider(supplied)
+			}
+
+			// Connect within the timeout as well
+			connected := make(chan *spike.Channel, 1)
+			failed := make(chan error, 1)
+			go func() {
+				channel, err := connectChannel(host, 8196, dialOptionsFromContext(c))
+				if err == nil {
+					if err = authenticate(channel, credentials); err != nil {
+						channel.Disconnect()
+					}
+				}
+				if err != nil {
+					failed <- err
+					return
+				}
+				connected <- channel
+			}()
+
+			var channel *spike.Channel
+			select {
+			case channel = <-connected:
+			case err := <-failed:
+				if certExpired(err) {
+					exitCheck(statusCritical, host+" certificate chain has expired", "")
+				}
+				exitCheck(statusCritical, "unable to connect to "+host+": "+err.Error(), "")
+			case <-deadline:
+				exitCheck(statusCritical, "timed out connecting to "+host, "")
+			}
+
+			// Send the pings, numbered so the replies are matched to their send time
+			guard := new(sync.Mutex)
+			sent := make([]time.Time, count)
+			done := make(chan bool)
+			go func() {
+				for i := 0; i < count; i++ {
+					guard.Lock()
+					sent[i] = time.Now()
+					guard.Unlock()
+
+					// The channel may be closed under our feet, the collection reports it
+					if channel.Ping(int32(i)) != nil {
+						return
+					}
+
+					select {
+					case <-time.After(interval):
+					case <-done:
+						return
+					}
+				}
+			}()
+
+			rtts := make([]float64, 0, count)
+			received := make(map[int32]bool)
+			var lost error
+		collect:
+			for len(received) < count {
+				select {
+				case msg := <-channel.OnPing:
+					if msg.Time < 0 || int(msg.Time) >= count || received[msg.Time] {
+						continue
+					}
+					received[msg.Time] = true
+					guard.Lock()
+					rtts = append(rtts, millis(time.Since(sent[msg.Time])))
+					guard.Unlock()
+				case lost = <-channel.OnDisconnect:
+					break collect
+				case <-deadline:
+					break collect
+				}
+			}
+			close(done)
+
+			// Revoke quietly, the output is a single line
+			if supplied, ok := channel.Authenticated(); ok && channel.IsOpen() {
+				channel.Revoke(supplied, credentialsTimeout)
+			}
+			channel.Disconnect()
+
+			// Evaluate the average round-trip time and the loss
+			loss := math.Round(10000*float64(count-len(rtts))/float64(count)) / 100
+			perfdata := rttPerfdata(rtts, warning, critical)
+			status := statusOk
+			message := fmt.Sprintf("%s loss %g%%", host, loss)
+			if len(rtts) > 0 {
+				rtt := summarize(rtts).Mean
+				message = fmt.Sprintf("%s rtt %.3f ms, loss %g%%", host, rtt, loss)
+				status = worstStatus(status, checkStatus(rtt, warning.rtt, critical.rtt))
+			}
+			perfdata += fmt.Sprintf(" loss=%g%%;%g;%g;0;100", loss, warning.loss, critical.loss)
+			status = worstStatus(status, checkStatus(loss, warning.loss, critical.loss))
+
+			if lost != nil {
+				message += ", connection lost: " + lost.Error()
+				status = statusCritical
+			}
+
+			if days, ok := certDays(channel); ok {
+				message += fmt.Sprintf(", certificate expires in %d days", days)
+				perfdata += fmt.Sprintf(" cert_days=%d;%d;%d", days, thresholds.warnDays, thresholds.critDays)
+				status = worstStatus(status, thresholds.status(days))
+			}
+
+			exitCheck(status, message, perfdata)
+		},
+	}
+}
+
+// Returns the performance data of the average round-trip time. When every ping
+// was lost the value is 'U', undetermined, so the series keeps its label.
+func rttPerfdata(rtts []float64, warning checkThreshold, critical checkThreshold) string {
+	if len(rtts) == 0 {
+		return fmt.Sprintf("rtt=U;%g;%g;0", warning.rtt, critical.rtt)
+	}
+	return fmt.Sprintf("rtt=%.3fms;%g;%g;0", summarize(rtts).Mean, warning.rtt, critical.rtt)
+}
+
+// Returns the status of a value against its thresholds, which are ignored if zero.
+func checkStatus(value float64, warning float64, critical float64) int {
+	switch {
+	case critical > 0 && value >= critical:
+		return statusCritical
+	case warning > 0 && value >= warning:
+		return statusWarning
+	}
+	return statusOk
+}
+
+// Parses a threshold in the 'RTT,LOSS%' format of check_ping.
+func parseCheckThreshold(value string) checkThreshold {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], "%") {
+		panic("invalid threshold '" + value + "', expected 'RTT,LOSS%'")
+	}
+
+	rtt, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		panic("invalid threshold '" + value + "': " + err.Error())
+	}
+	loss, err := strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64)
+	if err != nil {
+		panic("invalid threshold '" + value + "': " + err.Error())
+	}
+	return checkThreshold{rtt, loss}
+}
+
+// Prints the status line of the plugin and exits with the status.
+func exitCheck(status int, message string, perfdata string) {
+	line := "SPING " + statusLabels[status] + " - " + message
+	if perfdata != "" {
+		line += " | " + perfdata
+	}
+
+	fmt.Println(line)
+	os.Exit(status)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRttPerfdataKeepsTheLabel(t *testing.T) {
+	warning := checkThreshold{rtt: 100, loss: 20}
+	critical := checkThreshold{rtt: 500, loss: 60}
+
+	if got, want := rttPerfdata([]float64{10, 20}, warning, critical), "rtt=15.000ms;100;500;0"; got != want {
+		t.Errorf("the perfdata is %q, want %q", got, want)
+	}
+	if got, want := rttPerfdata(nil, warning, critical), "rtt=U;100;500;0"; got != want {
+		t.Errorf("the perfdata of a total loss is %q, want %q", got, want)
+	}
+}
+
+func TestParseCheckThreshold(t *testing.T) {
+	if got := parseCheckThreshold("100,20%"); got != (checkThreshold{rtt: 100, loss: 20}) {
+		t.Errorf("parsed %+v", got)
+	}
+	if got := parseCheckThreshold("0.5,2.5%"); got != (checkThreshold{rtt: 0.5, loss: 2.5}) {
+		t.Errorf("parsed %+v", got)
+	}
+
+	for _, value := range []string{"", "100", "100,20", "x,20%", "100,y%", "100,20%,5"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("the threshold %q was accepted", value)
+				}
+			}()
+			parseCheckThreshold(value)
+		}()
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	for _, c := range []struct {
+		value, warning, critical float64
+		want                     int
+	}{
+		{50, 100, 500, statusOk},
+		{100, 100, 500, statusWarning},
+		{499, 100, 500, statusWarning},
+		{500, 100, 500, statusCritical},
+		{1000, 0, 0, statusOk},
+		{1000, 0, 500, statusCritical},
+		{1000, 100, 0, statusWarning},
+	} {
+		if got := checkStatus(c.value, c.warning, c.critical); got != c.want {
+			t.Errorf("checkStatus(%g, %g, %g) = %s, want %s", c.value, c.warning, c.critical, statusLabels[got], statusLabels[c.want])
+		}
+	}
+}
+
+// Runs the check command in a child process, since it exits with its status,
+// and returns the exit code along with the status line.
+func runCheck(t *testing.T, args ...string) (int, string) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCheckProcess$")
+	cmd.Env = append(os.Environ(), "SPING_CHECK_ARGS="+strings.Join(args, "\n"))
+	output, err := cmd.Output()
+	code := 0
+	if exit, ok := err.(*exec.ExitError); ok {
+		code = exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return code, strings.TrimSpace(string(output))
+}
+
+// Runs the check command with the arguments of runCheck, in the child process.
+func TestCheckProcess(t *testing.T) {
+	args := os.Getenv("SPING_CHECK_ARGS")
+	if args == "" {
+		return
+	}
+	newApp().Run(append([]string{"sping", "check", "--proxy", "none"}, strings.Split(args, "\n")...))
+	t.Fatal("the check did not exit")
+}
+
+func TestCheckAgainstTheEmulator(t *testing.T) {
+	emulator := newTestEmulator(t)
+	code, line := runCheck(t, "-p", "3", "--interval", "10ms", "--timeout", "2s", emulator.Address())
+	if code != statusOk || !strings.HasPrefix(line, "SPING OK - ") || !strings.Contains(line, "loss 0%") {
+		t.Errorf("exited with %d: %q", code, line)
+	}
+
+	emulator.DropPings()
+	code, line = runCheck(t, "-p", "3", "--interval", "10ms", "--timeout", "500ms", emulator.Address())
+	if code != statusCritical || !strings.HasPrefix(line, "SPING CRITICAL - ") || !strings.Contains(line, "loss 100%") || !strings.Contains(line, "rtt=U;") {
+		t.Errorf("exited with %d: %q", code, line)
+	}
+}
+
+func TestCheckUnreachableHost(t *testing.T) {
+	// A port which was just released refuses the connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	code, line := runCheck(t, "--timeout", "2s", address)
+	if code != statusCritical || !strings.HasPrefix(line, "SPING CRITICAL - unable to connect to "+address) {
+		t.Errorf("exited with %d: %q", code, line)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"spike"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// Returns the command which estimates the offset of the server clock.
+func clockCommand() cli.Command {
+	return cli.Command{
+		Name:      "clock",
+		Usage:     "Estimates the offset and drift of the server clock using GetServerTime. Exits with 2 if the skew exceeds the threshold.",
+		ArgsUsage: "[host]",
+		Flags: append([]cli.Flag{
+			cli.IntFlag{
+				Name:  "count",
+				Value: 20,
+				Usage: "Sets the number of samples to take.",
+			},
+			cli.StringFlag{
+				Name:  "interval",
+				Value: "500ms",
+				Usage: "Sets the interval between two samples.",
+			},
+			cli.IntFlag{
+				Name:  "best",
+				Value: 5,
+				Usage: "Sets the number of lowest round-trip samples the offset is estimated from.",
+			},
+			cli.IntFlag{
+				Name:  "window",
+				Value: 10,
+				Usage: "Prints an intermediate estimate every this many samples, to follow the drift over time.",
+			},
+			cli.StringFlag{
+				Name:  "max-skew",
+				Value: "1s",
+				Usage: "Exits with 2 if the absolute offset of the server clock exceeds this threshold.",
+			},
+			cli.StringFlag{
+				Name:  "timeout",
+				Value: "5s",
+				Usage: "Sets how long to wait for each sample.",
+			},
+		}, append(credentialsFlags, dialFlags...)...),
+		Action: func(c *cli.Context) {
+			defer exitOnPanic()
+
+			host := "127.0.0.1:8002"
+			if len(c.Args()) > 0 {
+				host = c.Args()[0]
+			}
+			host = normalizeHost(host)
+
+			interval, err := time.ParseDuration(c.String("interval"))
+			if err != nil {
+				panic(err)
+			}
+			maxSkew, err := time.ParseDuration(c.String("max-skew"))
+			if err != nil {
+				panic(err)
+			}
+			timeout, err := time.ParseDuration(c.String("timeout"))
+			if err != nil {
+				panic(err)
+			}
+
+			credentials := credentialsFromContext(c)
+			channel, err := connectChannel(host, 8196, dialOptionsFromContext(c))
+			if err != nil {
+				panic(err)
+			}
+			if err := authenticate(channel, credentials); err != nil {
+				panic(err)
+			}
+
+			fmt.Println("Sampling the clock of", host)
+			samples := make([]spike.ClockSample, 0, c.Int("count"))
+			for i := 1; i <= c.Int("count"); i++ {
+				sample, err := channel.SampleClock(timeout)
+				if err != nil {
+					fmt.Println("Sample", i, "failed:", err)
+					continue
+				}
+
+				samples = append(samples, sample)
+				fmt.Printf("Sample %d: rtt %.3f ms, offset %+.3f ms ± %.3f ms.\n", i, millis(sample.Rtt()), millis(sample.Offset()), millis(sample.Uncertainty()))
+				if c.Int("window") > 0 && len(samples)%c.Int("window") == 0 && i < c.Int("count") {
+					estimate, _ := spike.EstimateClock(samples, c.Int("best"))
+					fmt.Printf("Estimate after %d samples: offset %+.3f ms ± %.3f ms, drift %+.1f ppm.\n", len(samples), millis(estimate.Offset), millis(estimate.Uncertainty), estimate.Drift)
+				}
+				time.Sleep(interval)
+			}
+
+			revoke(channel)
+			channel.Disconnect()
+
+			estimate, err := spike.EstimateClock(samples, c.Int("best"))
+			if err != nil {
+				panic(err)
+			}
+
+			fmt.Println()
+			fmt.Println("Clock statistics:")
+			fmt.Println("   Samples:     ", len(samples), "events")
+			fmt.Printf("   Offset:       %+.3f ms.\n", millis(estimate.Offset))
+			fmt.Printf("   Uncertainty:  %.3f ms.\n", millis(estimate.Uncertainty))
+			fmt.Printf("   Drift:        %+.1f ppm.\n", estimate.Drift)
+
+			skew := estimate.Offset
+			if skew < 0 {
+				skew = -skew
+			}
+			if skew > maxSkew {
+				fmt.Println("Clock skew of", skew, "exceeds", maxSkew)
+				os.Exit(2)
+			}
+		},
+	}
+}
+
+// Converts the duration to fractional milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"spike"
+	"text/tabwriter"
+	"time"
+)
+
+// The phases of establishing a connection, in order.
+var connectPhases = []string{"resolve", "connect", "tls", "auth", "first ping", "total"}
+
+// Represents a dialer which measures the time spent in each phase.
+type timedDialer interface {
+	DialTimed(address string) (net.Conn, spike.DialTiming, error)
+}
+
+// Represents the phase timings of the connections made to a target.
+type connectTest struct {
+	target     *Target
+	phases     map[string][]float64
+	failures   int
+	thresholds certThresholds
+	inspected  bool
+	status     int
+}
+
+// Repeatedly connects to every target, pings once and disconnects, then prints
+// the distribution of the time spent in each phase of establishing a connection.
+// Returns the worst status of the TLS certificates.
+func runConnectOnly(targets []*Target, count int, interval time.Duration, options dialOptions, credentials spike.CredentialProvider, thresholds certThresholds) int {
+	status := statusOk
+	tests := make([]*connectTest, 0, len(targets))
+	for _, target := range targets {
+		test := &connectTest{target: target, phases: make(map[string][]float64), thresholds: thresholds}
+		tests = append(tests, test)
+
+		fmt.Println("Connecting", count, "times to a Spike Engine service", target.Host)
+		for i := 1; i <= count; i++ {
+			timing, err := test.connect(options, credentials)
+			if err != nil {
+				fmt.Println("Connection", i, "to", target.Host, "failed:", err)
+				test.failures++
+				test.status = worstStatus(test.status, certErrorStatus(target.Host, err))
+			} else {
+				fmt.Printf("Connection %d to %s: %s.\n", i, target.Host, formatTiming(timing))
+				test.record(timing)
+			}
+
+			if i < count {
+				time.Sleep(interval)
+			}
+		}
+	}
+
+	for _, test := range tests {
+		test.print()
+		status = worstStatus(status, test.status)
+	}
+	return status
+}
+
+// Connects to the target, supplies the credentials, pings once and disconnects,
+// measuring every phase in milliseconds.
+func (this *connectTest) connect(options dialOptions, credentials spike.CredentialProvider) (map[string]float64, error) {
+	host := this.target.Host
+	dialer, err := dialerFor(host, 8196, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the TCP/IP dialer breaks the connection down, WebSocket is timed as a whole
+	timing := make(map[string]float64)
+	start := time.Now()
+	var conn io.ReadWriteCloser
+	if timed, ok := dialer.(timedDialer); ok {
+		var phases spike.DialTiming
+		if conn, phases, err = timed.DialTimed(dialAddress(host)); err != nil {
+			return nil, err
+		}
+		timing["connect"] = millis(phases.Connect)
+		if phases.Resolve > 0 {
+			timing["resolve"] = millis(phases.Resolve)
+		}
+		if phases.Handshake > 0 {
+			timing["tls"] = millis(phases.Handshake)
+		}
+	} else {
+		if conn, err = dialer.Dial(dialAddress(host)); err != nil {
+			return nil, err
+		}
+		timing["connect"] = millis(time.Since(start))
+	}
+
+	channel := spike.NewChannel(conn, 8196)
+	defer channel.Disconnect()
+
+	// The certificates are the same on every connection, inspect them once
+	if !this.inspected {
+		this.inspected = true
+		this.status = worstStatus(this.status, inspectTLS(host, channel, this.thresholds))
+	}
+
+	if credentials != nil {
+		phase := time.Now()
+		if err := authenticate(channel, credentials); err != nil {
+			return nil, err
+		}
+		timing["auth"] = millis(time.Since(phase))
+	}
+
+	phase := time.Now()
+	if err := channel.Ping(0); err != nil {
+		return nil, err
+	}
+	select {
+	case <-channel.OnPing:
+	case err := <-channel.OnDisconnect:
+		return nil, err
+	case <-time.After(credentialsTimeout):
+		return nil, fmt.Errorf("no ping received within %v", credentialsTimeout)
+	}
+	timing["first ping"] = millis(time.Since(phase))
+	timing["total"] = millis(time.Since(start))
+
+	revoke(channel)
+	return timing, nil
+}
+
+// Records the timing of a successful connection.
+func (this *connectTest) record(timing map[string]float64) {
+	for phase, value := range timing {
+		this.phases[phase] = append(this.phases[phase], value)
+	}
+}
+
+// Prints the distribution of each phase.
+func (this *connectTest) print() {
+	fmt.Println()
+	fmt.Println("Connection statistics of", this.target.Host+":", len(this.phases["total"]), "connected,", this.failures, "failed")
+	if len(this.phases["total"]) == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Phase\tMin\tMean\tMedian\t95th\t99th\tMax\t")
+	for _, phase := range connectPhases {
+		samples, ok := this.phases[phase]
+		if !ok {
+			continue
+		}
+
+		s := summarize(samples)
+		fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t\n", phase, s.Min, s.Mean, s.Median, s.P95, s.P99, s.Max)
+	}
+	w.Flush()
+}
+
+// Formats the timing of a connection, phase after phase.
+func formatTiming(timing map[string]float64) string {
+	line := ""
+	for _, phase := range connectPhases {
+		if value, ok := timing[phase]; ok {
+			if line != "" {
+				line += ", "
+			}
+			line += fmt.Sprintf("%s %.3f ms", phase, value)
+		}
+	}
+	return line
+}
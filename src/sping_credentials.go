@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"spike"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// The flags which configure the credentials supplied after connecting.
+var credentialsFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "user",
+		Value: "",
+		Usage: "Supplies credentials for this user name after connecting. Nothing is supplied if empty.",
+	},
+	cli.StringFlag{
+		Name:  "password-file",
+		Value: "",
+		Usage: "Reads the password of the user from this file, so it does not appear on the command line. The file must not be accessible by other users.",
+	},
+	cli.StringFlag{
+		Name:  "password-command",
+		Value: "",
+		Usage: "Runs this command on every connection and uses the first line of its output as the password of the user.",
+	},
+	cli.StringFlag{
+		Name:  "credentials-env",
+		Value: "",
+		Usage: "Reads the credentials from the environment variables with this prefix, such as 'SPING_' for SPING_USER, SPING_PASSWORD, SPING_DOMAIN, SPING_CREDENTIALS_URI and SPING_CREDENTIALS_TYPE.",
+	},
+	cli.StringFlag{
+		Name:  "domain",
+		Value: "",
+		Usage: "Sets the domain of the user.",
+	},
+	cli.StringFlag{
+		Name:  "credentials-uri",
+		Value: "",
+		Usage: "Sets the URI of the credentials, as expected by the service.",
+	},
+	cli.StringFlag{
+		Name:  "credentials-type",
+		Value: "",
+		Usage: "Sets the type of the credentials, as expected by the service.",
+	},
+}
+
+// How long to wait for the service to accept or revoke the credentials.
+const credentialsTimeout = 5 * time.Second
+
+// Builds the provider of the credentials from the command line, or returns nil
+// if no credentials were asked for.
+func credentialsFromContext(c *cli.Context) spike.CredentialProvider {
+	if c.String("credentials-env") != "" {
+		return spike.NewEnvCredentialProvider(c.String("credentials-env"))
+	}
+	if c.String("user") == "" {
+		return nil
+	}
+
+	credentials := spike.Credentials{
+		Uri:      c.String("credentials-uri"),
+		Type:     c.String("credentials-type"),
+		UserName: c.String("user"),
+		Domain:   c.String("domain"),
+	}
+
+	if command := strings.Fields(c.String("password-command")); len(command) > 0 {
+		return spike.NewCommandCredentialProvider(credentials, command[0], command[1:]...)
+	}
+	if c.String("password-file") != "" {
+		return spike.NewFileCredentialProvider(credentials, c.String("password-file"))
+	}
+	return spike.NewStaticCredentialProvider(credentials)
+}
+
+// Supplies the credentials, if any, on a freshly connected channel.
+func authenticate(channel *spike.Channel, provider spike.CredentialProvider) error {
+	if provider == nil {
+		return nil
+	}
+
+	credentials, err := provider.Credentials()
+	if err != nil {
+		return err
+	}
+	return channel.Authenticate(credentials, credentialsTimeout)
+}
+
+// Revokes the credentials the channel was authenticated with, if any, before a
+// clean shutdown. Those are revoked rather than asking the provider again, which
+// may run a command and return rotated credentials which were never supplied.
+// Nothing is revoked on a channel the server has already closed.
+func revoke(channel *spike.Channel) {
+	if channel == nil || !channel.IsOpen() {
+		return
+	}
+
+	credentials, ok := channel.Authenticated()
+	if !ok {
+		return
+	}
+	if err := channel.Revoke(credentials, credentialsTimeout); err != nil {
+		fmt.Println("Unable to revoke the credentials:", err)
+	}
+}
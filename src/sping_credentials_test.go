@@ -0,0 +1,31 @@
+package main
+
+import (
+	"spike"
+	"testing"
+	"time"
+)
+
+func TestRevokeSkipsClosedChannels(t *testing.T) {
+	emulator := newTestEmulator(t)
+	channel := dialTestEmulator(t, emulator, 0)
+	if err := channel.Authenticate(spike.Credentials{UserName: "u", Password: "p"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server went away before the Ctrl-C
+	channel.Disconnect()
+	deadline := time.Now().Add(2 * time.Second)
+	for channel.IsOpen() {
+		if time.Now().After(deadline) {
+			t.Fatal("the channel did not close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	revoke(channel)
+	if _, ok := channel.Authenticated(); !ok {
+		t.Error("the credentials were revoked on a closed channel")
+	}
+	revoke(nil)
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"spike"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// The states of the connection to a target of the daemon.
+const (
+	stateConnecting = "connecting"
+	stateUp         = "up"
+	stateDown       = "down"
+)
+
+// Represents the pings of a target over a window of time.
+type daemonWindow struct {
+	Start      time.Time  `json:"start"`
+	End        *time.Time `json:"end,omitempty"`
+	Sent       int        `json:"sent"`
+	Received   int        `json:"received"`
+	Lost       int        `json:"lost"`
+	Loss       float64    `json:"loss"`
+	Failures   int        `json:"failures"`
+	Reconnects int        `json:"reconnects"`
+	Rtt        *rttStats  `json:"rtt,omitempty"`
+
+	samples []float64
+}
+
+// Represents the distribution of the round-trip times of a window, in milliseconds.
+type rttStats struct {
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	Max    float64 `json:"max"`
+}
+
+// Represents the current health of a target, as reported by the status API.
+type targetStatus struct {
+	Name       string        `json:"name"`
+	Profile    string        `json:"profile"`
+	Host       string        `json:"host"`
+	State      string        `json:"state"`
+	Status     string        `json:"status"`
+	Since      time.Time     `json:"since"`
+	Error      string        `json:"error,omitempty"`
+	Rtt        *float64      `json:"rtt,omitempty"`
+	CertDays   *int          `json:"cert_days,omitempty"`
+	Reconnects int           `json:"reconnects"`
+	Window     *daemonWindow `json:"window,omitempty"`
+}
+
+// Represents a target which the daemon pings indefinitely, reconnecting whenever
+// the connection is lost.
+type daemonTarget struct {
+	name        string
+	profile     string
+	host        string
+	interval    time.Duration
+	timeout     time.Duration
+	delay       time.Duration
+	warning     checkThreshold
+	critical    checkThreshold
+	thresholds  certThresholds
+	options     dialOptions
+	credentials spike.CredentialProvider
+
+	guard      *sync.Mutex
+	channel    *spike.Channel
+	state      string
+	since      time.Time
+	err        error
+	rtt        *float64
+	certDays   *int
+	reconnects int
+	pending    map[int32]time.Time
+	current    *daemonWindow
+	history    []*daemonWindow
+	size       int
+	stopped    bool
+}
+
+// Represents the probes run by the daemon, by the name of their targets.
+type daemon struct {
+	started time.Time
+	targets map[string]*daemonTarget
+}
+
+// Returns the command which runs the probes of the configuration indefinitely
+// and serves their health over HTTP.
+func daemonCommand() cli.Command {
+	return cli.Command{
+		Name:      "daemon",
+		Usage:     "Pings the targets of the probe profiles indefinitely, reconnecting as needed, and serves their health over HTTP on /status, /targets/NAME/history and /healthz.",
+		ArgsUsage: "[PROFILE...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "config",
+				Value: "",
+				Usage: "Reads the profiles from this file. Uses SPING_CONFIG if empty, or the first of ./sping.yaml, ./sping.yml, ./sping.json, ./sping.toml, ~/.config/sping/sping.yaml and /etc/sping/sping.yaml.",
+			},
+			cli.StringFlag{
+				Name:  "listen",
+				Value: "127.0.0.1:8088",
+				Usage: "Serves the HTTP API on this address.",
+			},
+			cli.StringFlag{
+				Name:  "window",
+				Value: "1m",
+				Usage: "Sets the duration of the windows the history is made of.",
+			},
+			cli.IntFlag{
+				Name:  "history",
+				Value: 60,
+				Usage: "Sets the number of windows kept in the history of every target.",
+			},
+			cli.StringFlag{
+				Name:  "ping-timeout",
+				Value: "5s",
+				Usage: "Counts a ping as lost if unanswered within this time, and reconnects if nothing at all is received within it.",
+			},
+			cli.StringFlag{
+				Name:  "connect-timeout",
+				Value: "10s",
+				Usage: "Gives up a connection attempt to a target after this time, which then counts as a failure.",
+			},
+			cli.StringFlag{
+				Name:  "reconnect-delay",
+				Value: "1s",
+				Usage: "Sets the delay between two connection attempts to a target.",
+			},
+		},
+		Action: func(c *cli.Context) {
+			defer exitOnPanic()
+
+			config, err := LoadConfig(c.String("config"))
+			if err != nil {
+				panic(err)
+			}
+			names := []string(c.Args())
+			if len(names) == 0 {
+				names = config.Names()
+			}
+
+			window := parseDuration(c.String("window"))
+			timeout := parseDuration(c.String("ping-timeout"))
+			delay := parseDuration(c.String("reconnect-delay"))
+			connectTimeout := parseDuration(c.String("connect-timeout"))
+			if c.Int("history") < 1 {
+				panic("the history must hold at least one window")
+			}
+
+			// Build every target first, so a broken profile runs nothing
+			d := &daemon{started: time.Now(), targets: make(map[string]*daemonTarget)}
+			for _, name := range names {
+				targets, err := daemonTargets(config, name)
+				if err != nil {
+					panic(err)
+				}
+				for _, target := range targets {
+					target.timeout = timeout
+					target.delay = delay
+					target.options.timeout = connectTimeout
+					target.size = c.Int("history")
+					d.targets[target.name] = target
+				}
+			}
+			if len(d.targets) == 0 {
+				panic("no profile to run")
+			}
+
+			for _, name := range d.names() {
+				fmt.Println("Starting pinging", name, "at", d.targets[name].host)
+				go d.targets[name].run()
+			}
+			go d.rotate(window)
+
+			// Revoke the credentials before leaving
+			schan := make(chan os.Signal, 1)
+			signal.Notify(schan, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				sig := <-schan
+				fmt.Println(sig, "received, stopping")
+				for _, target := range d.targets {
+					target.stop()
+				}
+				os.Exit(0)
+			}()
+
+			fmt.Println("Serving the status API on", c.String("listen"))
+			panic(http.ListenAndServe(c.String("listen"), d.handler()))
+		},
+	}
+}
+
+// Builds the targets of the profile, named after it and numbered if there are several.
+// Only the profiles which ping, directly or as a check, can run in the daemon.
+func daemonTargets(config *Config, name string) ([]*daemonTarget, error) {
+	profile, ok := config.Probes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile '%s'", name)
+	}
+	if command := commandName(profile.Command()); command != "ping" && command != "check" {
+		return nil, fmt.Errorf("profile '%s': the daemon only runs ping and check profiles, not %s", name, command)
+	}
+
+	c, err := config.Context(name)
+	if err != nil {
+		return nil, err
+	}
+	return targetsFromContext(c, name)
+}
+
+// Reads the targets and the probe settings from the flags of the profile.
+func targetsFromContext(c *cli.Context, name string) (targets []*daemonTarget, err error) {
+	// The flags are checked by panicking, as on the command line
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("profile '%s': %v", name, r)
+		}
+	}()
+
+	// The daemon serves its measurements itself, rather than silently dropping these
+	for _, flag := range []string{"out", "statsd", "influx"} {
+		if c.String(flag) != "" {
+			panic("the daemon does not push to the outputs, remove --" + flag + " and use the status API")
+		}
+	}
+	if c.Int("size") > 0 || c.Bool("connect-only") {
+		panic("the daemon only sends plain pings, remove --size and --connect-only")
+	}
+
+	hosts := []string(c.Args())
+	if c.String("targets") != "" {
+		list, err := readTargets(c.String("targets"))
+		if err != nil {
+			panic(err)
+		}
+		hosts = append(hosts, list...)
+	}
+	if len(hosts) == 0 {
+		hosts = append(hosts, "127.0.0.1:8002")
+	}
+
+	template := daemonTarget{
+		profile:     name,
+		interval:    time.Second,
+		thresholds:  certThresholds{c.Int("warn-cert-days"), c.Int("crit-cert-days")},
+		options:     dialOptionsFromContext(c),
+		credentials: credentialsFromContext(c),
+	}
+	if c.String("interval") != "" {
+		template.interval = parseDuration(c.String("interval"))
+	}
+	if c.String("warning") != "" {
+		template.warning = parseCheckThreshold(c.String("warning"))
+	}
+	if c.String("critical") != "" {
+		template.critical = parseCheckThreshold(c.String("critical"))
+	}
+
+	for i, host := range hosts {
+		target := template
+		target.guard = new(sync.Mutex)
+		target.name = name
+		if len(hosts) > 1 {
+			target.name = fmt.Sprintf("%s-%d", name, i+1)
+		}
+		target.host = normalizeHost(host)
+		targets = append(targets, &target)
+	}
+	return targets, nil
+}
+
+// Connects to the target and pings it until the connection is lost, forever.
+func (this *daemonTarget) run() {
+	this.guard.Lock()
+	this.current = &daemonWindow{Start: time.Now()}
+	this.guard.Unlock()
+
+	// Only the first of consecutive failures is printed
+	failing := false
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(this.delay)
+		}
+		this.setState(stateConnecting, nil)
+
+		channel, err := connectChannel(this.host, 8196, this.options)
+		if err == nil {
+			if err = authenticate(channel, this.credentials); err != nil {
+				channel.Disconnect()
+			}
+		}
+		if err != nil {
+			if !failing {
+				fmt.Println("Unable to connect to", this.name, "at", this.host+", retrying every", this.delay.String()+":", err)
+			}
+			failing = true
+			this.fail(err)
+			continue
+		}
+
+		failing = false
+		fmt.Println("Connected to", this.name, "at", this.host)
+		this.connected(channel, attempt > 0)
+		err = this.ping(channel)
+		if this.isStopped() {
+			return
+		}
+		fmt.Println("Lost the connection to", this.name, "at", this.host+":", err)
+		this.fail(err)
+	}
+}
+
+// Pings over the channel every interval until it is closed, and returns why.
+func (this *daemonTarget) ping(channel *spike.Channel) error {
+	// A silent peer is detected by the heartbeat, which closes the channel
+	channel.StartHeartbeat(this.timeout/3, this.timeout)
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	var sequence int32
+	send := func() error {
+		this.guard.Lock()
+		this.pending[sequence] = time.Now()
+		this.current.Sent++
+		this.guard.Unlock()
+		err := channel.Ping(sequence)
+		sequence = (sequence + 1) & 0x7fffffff
+		return err
+	}
+
+	// The channel may close between two pings, the reason is then preferred
+	closed := func(err error) error {
+		select {
+		case reason := <-channel.OnDisconnect:
+			return reason
+		default:
+			return err
+		}
+	}
+
+	if err := send(); err != nil {
+		return closed(err)
+	}
+	for {
+		select {
+		case <-ticker.C:
+			this.expire()
+			if err := send(); err != nil {
+				return closed(err)
+			}
+		case msg := <-channel.OnPing:
+			this.receive(msg.Time)
+		case err := <-channel.OnDisconnect:
+			return err
+		}
+	}
+}
+
+// Records the reply to a ping.
+func (this *daemonTarget) receive(sequence int32) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+
+	sent, ok := this.pending[sequence]
+	if !ok {
+		return
+	}
+	delete(this.pending, sequence)
+
+	rtt := millis(time.Since(sent))
+	this.rtt = &rtt
+	this.current.Received++
+	this.current.samples = append(this.current.samples, rtt)
+}
+
+// Counts the pings unanswered within the timeout as lost.
+func (this *daemonTarget) expire() {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+
+	for sequence, sent := range this.pending {
+		if time.Since(sent) >= this.timeout {
+			delete(this.pending, sequence)
+			this.current.Lost++
+		}
+	}
+}
+
+// Records a new connection to the target.
+func (this *daemonTarget) connected(channel *spike.Channel, reconnected bool) {
+	days, encrypted := certDays(channel)
+
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	this.channel = channel
+	this.pending = make(map[int32]time.Time)
+	if encrypted {
+		this.certDays = &days
+	}
+	if reconnected {
+		this.reconnects++
+		this.current.Reconnects++
+	}
+	this.setStateLocked(stateUp, nil)
+}
+
+// Records a failed connection attempt or a lost connection, the pending pings being lost.
+func (this *daemonTarget) fail(err error) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	this.channel = nil
+	this.current.Lost += len(this.pending)
+	this.pending = nil
+	this.current.Failures++
+	this.setStateLocked(stateDown, err)
+}
+
+// Changes the state of the connection to the target.
+func (this *daemonTarget) setState(state string, err error) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	this.setStateLocked(state, err)
+}
+
+// Changes the state of the connection to the target, the guard being held.
+func (this *daemonTarget) setStateLocked(state string, err error) {
+	// Reconnecting keeps the time the target went down
+	if this.since.IsZero() || (state == stateUp) != (this.state == stateUp) {
+		this.since = time.Now()
+	}
+	this.state = state
+	if err != nil || state == stateUp {
+		this.err = err
+	}
+}
+
+// Revokes the credentials and closes the connection, if any.
+func (this *daemonTarget) stop() {
+	this.guard.Lock()
+	channel := this.channel
+	this.stopped = true
+	this.guard.Unlock()
+
+	// The server may have closed the channel already
+	if channel != nil && channel.IsOpen() {
+		revoke(channel)
+		channel.Disconnect()
+	}
+}
+
+// Checks whether the daemon is stopping.
+func (this *daemonTarget) isStopped() bool {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	return this.stopped
+}
+
+// Closes the current window, moves it to the history and opens a new one.
+func (this *daemonTarget) rotate(now time.Time) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	if this.current == nil {
+		return
+	}
+
+	this.history = append(this.history, this.current.close(now))
+	if len(this.history) > this.size {
+		this.history = this.history[len(this.history)-this.size:]
+	}
+	this.current = &daemonWindow{Start: now}
+}
+
+// Returns the current health of the target.
+func (this *daemonTarget) status() targetStatus {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+
+	status := targetStatus{
+		Name:       this.name,
+		Profile:    this.profile,
+		Host:       this.host,
+		State:      this.state,
+		Since:      this.since,
+		CertDays:   this.certDays,
+		Reconnects: this.reconnects,
+	}
+	if this.err != nil {
+		status.Error = this.err.Error()
+	}
+	if this.rtt != nil {
+		rtt := roundTo(*this.rtt, 3)
+		status.Rtt = &rtt
+	}
+
+	// The health is judged on the last complete window, or on the current one at first
+	var window *daemonWindow
+	if len(this.history) > 0 {
+		window = this.history[len(this.history)-1]
+	} else if this.current != nil {
+		window = this.current.close(time.Now())
+	}
+	status.Window = window
+	status.Status = statusLabels[this.health(window)]
+	return status
+}
+
+// Returns the status of the target over the window, the guard being held. Retrying
+// after a failure is as bad as being down, and so is a window in which the target
+// failed without answering a single ping.
+func (this *daemonTarget) health(window *daemonWindow) int {
+	switch {
+	case this.state == stateDown:
+		return statusCritical
+	case this.state == stateConnecting && this.err != nil:
+		return statusCritical
+	case window != nil && window.Failures > 0 && window.Received == 0:
+		return statusCritical
+	case this.state != stateUp && window == nil:
+		return statusUnknown
+	}
+
+	status := statusOk
+	if window != nil && window.Rtt != nil {
+		status = worstStatus(status, checkStatus(window.Rtt.Mean, this.warning.rtt, this.critical.rtt))
+	}
+	if window != nil && window.Sent > 0 {
+		status = worstStatus(status, checkStatus(window.Loss, this.warning.loss, this.critical.loss))
+	}
+	if this.certDays != nil {
+		status = worstStatus(status, this.thresholds.status(*this.certDays))
+	}
+	return status
+}
+
+// Returns the history of the target, oldest first, the current window last.
+func (this *daemonTarget) windows() []*daemonWindow {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+
+	windows := append([]*daemonWindow{}, this.history...)
+	if this.current != nil {
+		current := this.current.close(time.Now())
+		current.End = nil
+		windows = append(windows, current)
+	}
+	return windows
+}
+
+// Returns a copy of the window with its loss and round-trip times computed.
+func (this *daemonWindow) close(end time.Time) *daemonWindow {
+	window := *this
+	window.End = &end
+	window.samples = nil
+	if answered := window.Received + window.Lost; answered > 0 {
+		window.Loss = roundTo(100*float64(window.Lost)/float64(answered), 2)
+	}
+	if len(this.samples) > 0 {
+		s := summarize(this.samples)
+		window.Rtt = &rttStats{
+			Min:    roundTo(s.Min, 3),
+			Mean:   roundTo(s.Mean, 3),
+			Median: roundTo(s.Median, 3),
+			P95:    roundTo(s.P95, 3),
+			P99:    roundTo(s.P99, 3),
+			Max:    roundTo(s.Max, 3),
+		}
+	}
+	return &window
+}
+
+// Returns the names of the targets, sorted.
+func (this *daemon) names() []string {
+	names := make([]string, 0, len(this.targets))
+	for name := range this.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Moves the windows of every target to their history, every period.
+func (this *daemon) rotate(period time.Duration) {
+	for now := range time.Tick(period) {
+		for _, target := range this.targets {
+			target.rotate(now)
+		}
+	}
+}
+
+// Returns the handler of the HTTP API.
+func (this *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r) {
+			return
+		}
+
+		targets := make([]targetStatus, 0, len(this.targets))
+		for _, name := range this.names() {
+			targets = append(targets, this.targets[name].status())
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"started": this.started,
+			"targets": targets,
+		})
+	})
+
+	mux.HandleFunc("/targets/", func(w http.ResponseWriter, r *http.Request) {
+		// Only /targets/NAME/history exists
+		name := strings.TrimPrefix(r.URL.Path, "/targets/")
+		if !strings.HasSuffix(name, "/history") {
+			http.NotFound(w, r)
+			return
+		}
+		name = strings.TrimSuffix(name, "/history")
+		target, ok := this.targets[name]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown target '" + name + "'"})
+			return
+		}
+		if !allowGet(w, r) {
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":    target.name,
+			"host":    target.host,
+			"windows": target.windows(),
+		})
+	})
+	return mux
+}
+
+// Checks that the request reads, and answers 405 otherwise.
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	return false
+}
+
+// Writes the value as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// Parses a duration, panicking if it is invalid.
+func parseDuration(value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic(err)
+	}
+	if duration <= 0 {
+		panic("the duration '" + value + "' must be positive")
+	}
+	return duration
+}
+
+// Rounds the value to the number of decimals.
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Builds the targets of a single profile, the way the daemon command does.
+func newTestDaemon(t *testing.T, profile Profile) *daemon {
+	config := &Config{Probes: map[string]Profile{"edge": profile}}
+	targets, err := daemonTargets(config, "edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &daemon{started: time.Now(), targets: make(map[string]*daemonTarget)}
+	for _, target := range targets {
+		target.timeout = time.Second
+		target.delay = 10 * time.Millisecond
+		target.options.timeout = time.Second
+		target.size = 10
+		d.targets[target.name] = target
+	}
+	return d
+}
+
+// Gets the path from the API and decodes its JSON body.
+func getJSON(t *testing.T, server *httptest.Server, path string, value interface{}) int {
+	response, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if value != nil {
+		if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return response.StatusCode
+}
+
+func TestDaemonServesTheHealthOfTheTargets(t *testing.T) {
+	emulator := newTestEmulator(t)
+	d := newTestDaemon(t, Profile{"target": emulator.Address(), "interval": "10ms"})
+	target := d.targets["edge"]
+	go target.run()
+	defer target.stop()
+
+	server := httptest.NewServer(d.handler())
+	defer server.Close()
+
+	// Wait for a few pongs, then close the window
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		target.guard.Lock()
+		received := target.current != nil && target.current.Received >= 3
+		target.guard.Unlock()
+		if received {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the emulator never answered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	target.rotate(time.Now())
+
+	var status struct {
+		Targets []targetStatus `json:"targets"`
+	}
+	if code := getJSON(t, server, "/status", &status); code != http.StatusOK {
+		t.Fatalf("/status answered %d", code)
+	}
+	if len(status.Targets) != 1 {
+		t.Fatalf("/status lists %d targets, want 1", len(status.Targets))
+	}
+	got := status.Targets[0]
+	if got.Name != "edge" || got.State != stateUp || got.Status != statusLabels[statusOk] {
+		t.Errorf("/status reports %s %s %s, want edge up and %s", got.Name, got.State, got.Status, statusLabels[statusOk])
+	}
+	if got.Window == nil || got.Window.Received < 3 || got.Window.Rtt == nil {
+		t.Errorf("/status reports the window %+v", got.Window)
+	}
+
+	var history struct {
+		Name    string          `json:"name"`
+		Windows []*daemonWindow `json:"windows"`
+	}
+	if code := getJSON(t, server, "/targets/edge/history", &history); code != http.StatusOK {
+		t.Fatalf("/targets/edge/history answered %d", code)
+	}
+	if history.Name != "edge" || len(history.Windows) != 2 || history.Windows[1].End != nil {
+		t.Errorf("the history holds %d windows, want the closed one and the current one", len(history.Windows))
+	}
+
+	if code := getJSON(t, server, "/targets/nope/history", nil); code != http.StatusNotFound {
+		t.Errorf("an unknown target answered %d, want 404", code)
+	}
+
+	response, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("/healthz answered %d", response.StatusCode)
+	}
+
+	response, err = http.Post(server.URL+"/status", "text/plain", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status answered %d, want 405", response.StatusCode)
+	}
+}
+
+func TestDaemonHealthWhileRetrying(t *testing.T) {
+	target := &daemonTarget{state: stateConnecting}
+	if status := target.health(nil); status != statusUnknown {
+		t.Errorf("a target connecting for the first time is %s, want UNKNOWN", statusLabels[status])
+	}
+
+	// Retrying after a failure, the last window having sent nothing
+	target.err = errors.New("connection refused")
+	window := &daemonWindow{Failures: 1}
+	if status := target.health(window); status != statusCritical {
+		t.Errorf("a target retrying after a failure is %s, want CRITICAL", statusLabels[status])
+	}
+
+	// Up again, but the last complete window only saw failures
+	target.state, target.err = stateUp, nil
+	if status := target.health(window); status != statusCritical {
+		t.Errorf("a window without any answer is %s, want CRITICAL", statusLabels[status])
+	}
+	window.Received = 1
+	if status := target.health(window); status != statusOk {
+		t.Errorf("a window with answers is %s, want OK", statusLabels[status])
+	}
+}
+
+func TestDaemonRejectsOutputs(t *testing.T) {
+	for _, profile := range []Profile{
+		{"statsd": "127.0.0.1:8125"},
+		{"influx": "udp://127.0.0.1:8089"},
+		{"out": "latencies.txt"},
+		{"size": 1024},
+	} {
+		config := &Config{Probes: map[string]Profile{"edge": profile}}
+		if _, err := daemonTargets(config, "edge"); err == nil {
+			t.Errorf("the daemon accepted the profile %v", profile)
+		}
+	}
+}
+
+func TestDaemonReconnectsAfterADrop(t *testing.T) {
+	emulator := newTestEmulator(t)
+	d := newTestDaemon(t, Profile{"target": emulator.Address(), "interval": "10ms"})
+	target := d.targets["edge"]
+	go target.run()
+	defer target.stop()
+
+	// Waits until the target is in the state, with the number of reconnections
+	wait := func(state string, reconnects int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			target.guard.Lock()
+			ok := target.state == state && target.reconnects == reconnects && target.current.Received > 0
+			target.guard.Unlock()
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the target is not %s after %d reconnections", state, reconnects)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	wait(stateUp, 0)
+	emulator.DropConnections()
+	wait(stateUp, 1)
+
+	target.guard.Lock()
+	failures := target.current.Failures
+	target.guard.Unlock()
+	if failures != 1 {
+		t.Errorf("the drop counted %d failures, want 1", failures)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"spike"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// Returns the command which pings every address behind a service name.
+func dnsCommand() cli.Command {
+	return cli.Command{
+		Name:      "dns",
+		Usage:     "Resolves every A/AAAA record behind a service name and pings each address in parallel, re-resolving periodically.",
+		ArgsUsage: "[host]",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "interval",
+				Value: "250ms",
+				Usage: "Sets the interval between two pings to each address.",
+			},
+			cli.StringFlag{
+				Name:  "resolve-interval",
+				Value: "30s",
+				Usage: "Sets the interval between two resolutions of the name. Failed addresses are reconnected on every resolution.",
+			},
+		}, append(credentialsFlags, dialFlags...)...),
+		Action: func(c *cli.Context) {
+			defer exitOnPanic()
+
+			host := "127.0.0.1:8002"
+			if len(c.Args()) > 0 {
+				host = c.Args()[0]
+			}
+
+			name, port, err := net.SplitHostPort(normalizeHost(host))
+			if err != nil {
+				panic("expected a 'host:port' service: " + err.Error())
+			}
+
+			test := new(dnsTest)
+			test.name = name
+			test.port = port
+			test.credentials = credentialsFromContext(c)
+			test.dial = dialOptionsFromContext(c)
+			test.t0 = time.Now()
+			test.lookup = net.LookupIP
+			test.guard = new(sync.Mutex)
+			test.probers = make(map[string]*addressProber)
+			if test.interval, err = time.ParseDuration(c.String("interval")); err != nil {
+				panic(err)
+			}
+			resolveInterval, err := time.ParseDuration(c.String("resolve-interval"))
+			if err != nil {
+				panic(err)
+			}
+
+			if err := test.resolve(); err != nil {
+				panic(err)
+			}
+
+			// Print the statistics per address on CTRL+C
+			schan := make(chan os.Signal, 1)
+			signal.Notify(schan, os.Interrupt)
+			go func() {
+				<-schan
+				test.stop()
+				test.printTable()
+				os.Exit(0)
+			}()
+
+			for range time.Tick(resolveInterval) {
+				if err := test.resolve(); err != nil {
+					fmt.Println("Unable to resolve", test.name, err)
+				}
+			}
+		},
+	}
+}
+
+// Represents the probing of every address behind a service name.
+type dnsTest struct {
+	name        string
+	port        string
+	interval    time.Duration
+	credentials spike.CredentialProvider
+	dial        dialOptions
+	t0          time.Time
+	lookup      func(name string) ([]net.IP, error)
+
+	guard   *sync.Mutex
+	records []string
+	probers map[string]*addressProber
+}
+
+// Represents the probing of a single address.
+type addressProber struct {
+	target   *Target
+	ip       string
+	sent     uint64
+	received uint64
+	failures int
+	removed  bool
+	running  bool
+	done     chan bool // closed to stop the current run, nil once closed
+}
+
+// Resolves the name, logs the changes of the record set and starts probing the
+// new addresses, as well as the ones which failed so far.
+func (this *dnsTest) resolve() error {
+	addresses, err := this.lookup(this.name)
+	if err != nil {
+		return err
+	}
+
+	records := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if this.dial.accepts(address) {
+			records = append(records, address.String())
+		}
+	}
+	sort.Strings(records)
+
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	if this.records == nil {
+		fmt.Println("Resolved", this.name, "to", strings.Join(records, ", "))
+	} else if added, removed := diffRecords(this.records, records); len(added) > 0 || len(removed) > 0 {
+		fmt.Println("Records of", this.name, "changed: added", listOrNone(added), "removed", listOrNone(removed))
+	}
+	this.records = records
+
+	current := make(map[string]bool)
+	for _, ip := range records {
+		current[ip] = true
+		prober, ok := this.probers[ip]
+		if !ok {
+			prober = &addressProber{ip: ip, target: NewTarget(net.JoinHostPort(ip, this.port))}
+			this.probers[ip] = prober
+		}
+		prober.removed = false
+		if !prober.running {
+			prober.running = true
+			prober.done = make(chan bool)
+			go this.probe(prober, prober.done)
+		}
+	}
+
+	// Stop probing the addresses which are no longer published. A run which was
+	// told to stop may still be running when the address is removed once more.
+	for ip, prober := range this.probers {
+		if !current[ip] && !prober.removed {
+			prober.removed = true
+			if prober.running && prober.done != nil {
+				close(prober.done)
+				prober.done = nil
+			}
+		}
+	}
+	return nil
+}
+
+// Connects to the address and pings it until it fails or the done channel of
+// the run is closed, once the address is removed.
+func (this *dnsTest) probe(prober *addressProber, done <-chan bool) {
+	defer func() {
+		this.guard.Lock()
+		prober.running = false
+		this.guard.Unlock()
+	}()
+
+	target := prober.target
+	channel, err := connectChannel(target.Host, 8196, this.dial)
+	if err == nil {
+		if err = authenticate(channel, this.credentials); err != nil {
+			channel.Disconnect()
+		}
+	}
+	if err != nil {
+		this.fail(prober, "Unable to connect to", err)
+		return
+	}
+
+	this.guard.Lock()
+	target.Channel = channel
+	target.Failed = nil
+	this.guard.Unlock()
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-channel.OnPing:
+			rtt := int32(time.Now().Sub(this.t0)/time.Millisecond) - msg.Time
+			atomic.AddUint64(&prober.received, 1)
+			fmt.Println("Pinging", this.name, "at", target.Host, "with 12 bytes of data:", rtt, "ms.")
+			target.Record(float64(rtt), true)
+
+		case now := <-ticker.C:
+			if err := channel.Ping(int32(now.Sub(this.t0) / time.Millisecond)); err != nil {
+				this.fail(prober, "Lost the connection to", err)
+				return
+			}
+			atomic.AddUint64(&prober.sent, 1)
+
+		case err := <-channel.OnDisconnect:
+			this.fail(prober, "Lost the connection to", err)
+			return
+
+		case <-done:
+			fmt.Println("Stopped pinging", target.Host, "which is no longer a record of", this.name)
+			revoke(channel)
+			channel.Disconnect()
+			return
+		}
+	}
+}
+
+// Records the failure of an address, which is retried on the next resolution.
+func (this *dnsTest) fail(prober *addressProber, message string, err error) {
+	fmt.Println(message, prober.target.Host, err)
+
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	prober.target.Failed = err
+	prober.failures++
+}
+
+// Revokes the credentials and disconnects from every address.
+func (this *dnsTest) stop() {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	for _, prober := range this.probers {
+		if prober.running && prober.target.Channel != nil {
+			revoke(prober.target.Channel)
+		}
+	}
+}
+
+// Prints a table with the statistics of every address probed so far.
+func (this *dnsTest) printTable() {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+
+	ips := make([]string, 0, len(this.probers))
+	for ip := range this.probers {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	fmt.Println()
+	fmt.Println("Ping statistics per address of", this.name+":")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Address\tStatus\tSent\tLost\tFailures\tMin\tMean\t95th\tMax\t")
+	for _, ip := range ips {
+		prober := this.probers[ip]
+		status := "ok"
+		switch {
+		case prober.removed:
+			status = "removed"
+		case prober.target.Failed != nil:
+			status = "failed"
+		}
+
+		sent := atomic.LoadUint64(&prober.sent)
+		lost := sent - atomic.LoadUint64(&prober.received)
+		samples := prober.target.Samples()
+		if len(samples) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t-\t-\t-\t-\t\n", ip, status, sent, lost, prober.failures)
+			continue
+		}
+
+		s := summarize(samples)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%g\t%.2f\t%g\t%g\t\n", ip, status, sent, lost, prober.failures, s.Min, s.Mean, s.P95, s.Max)
+	}
+	w.Flush()
+}
+
+// Returns the records which were added to and removed from the set.
+func diffRecords(previous []string, current []string) (added []string, removed []string) {
+	seen := make(map[string]bool)
+	for _, ip := range previous {
+		seen[ip] = true
+	}
+	for _, ip := range current {
+		if !seen[ip] {
+			added = append(added, ip)
+		}
+		delete(seen, ip)
+	}
+	for _, ip := range previous {
+		if seen[ip] {
+			removed = append(removed, ip)
+		}
+	}
+	return
+}
+
+// Joins the list, or returns 'none' if it is empty.
+func listOrNone(list []string) string {
+	if len(list) == 0 {
+		return "none"
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiffRecords(t *testing.T) {
+	added, removed := diffRecords([]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.2", "10.0.0.3"})
+	if !reflect.DeepEqual(added, []string{"10.0.0.3"}) || !reflect.DeepEqual(removed, []string{"10.0.0.1"}) {
+		t.Errorf("added %v and removed %v", added, removed)
+	}
+
+	added, removed = diffRecords([]string{"10.0.0.1"}, []string{"10.0.0.1"})
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("an unchanged set added %v and removed %v", added, removed)
+	}
+	if got := listOrNone(nil); got != "none" {
+		t.Errorf("an empty list is %q", got)
+	}
+}
+
+// Builds a test which resolves the name to the records returned by the function
+// and probes them on the port of the emulator.
+func newTestDnsTest(t *testing.T, emulator *testEmulator, records func() []string) *dnsTest {
+	_, port, err := net.SplitHostPort(emulator.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := &dnsTest{
+		name:     "spike.test",
+		port:     port,
+		interval: 10 * time.Millisecond,
+		dial:     dialOptions{proxy: "none", network: "tcp", timeout: time.Second},
+		t0:       time.Now(),
+		guard:    new(sync.Mutex),
+		probers:  make(map[string]*addressProber),
+	}
+	test.lookup = func(name string) ([]net.IP, error) {
+		ips := make([]net.IP, 0)
+		for _, record := range records() {
+			ips = append(ips, net.ParseIP(record))
+		}
+		return ips, nil
+	}
+	return test
+}
+
+// Waits until the condition holds, checked under the guard of the test.
+func waitDns(t *testing.T, test *dnsTest, what string, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		test.guard.Lock()
+		ok := condition()
+		test.guard.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDnsResolveProbesTheRecords(t *testing.T) {
+	emulator := newTestEmulator(t)
+
+	// The emulator only listens on 127.0.0.1, so 127.0.0.2 refuses the connection
+	var records atomic.Value
+	records.Store([]string{"127.0.0.1", "127.0.0.2"})
+	test := newTestDnsTest(t, emulator, func() []string { return records.Load().([]string) })
+	if err := test.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	up, down := test.probers["127.0.0.1"], test.probers["127.0.0.2"]
+	waitDns(t, test, "the address was not pinged", func() bool { return atomic.LoadUint64(&up.received) > 0 })
+	waitDns(t, test, "the refused address did not fail", func() bool { return down.failures == 1 && !down.running })
+
+	// The failed address is retried on the next resolution
+	if err := test.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	waitDns(t, test, "the refused address was not retried", func() bool { return down.failures == 2 && !down.running })
+
+	// Removed, published again while it still stops, then removed once more
+	records.Store([]string{"127.0.0.2"})
+	test.resolve()
+	records.Store([]string{"127.0.0.1", "127.0.0.2"})
+	test.resolve()
+	records.Store([]string{"127.0.0.2"})
+	test.resolve()
+	waitDns(t, test, "the removed address was still pinged", func() bool { return up.removed && !up.running })
+	if !reflect.DeepEqual(test.records, []string{"127.0.0.2"}) {
+		t.Errorf("the records are %v", test.records)
+	}
+
+	// Published again, it is probed from scratch
+	records.Store([]string{"127.0.0.1"})
+	test.resolve()
+	received := atomic.LoadUint64(&up.received)
+	waitDns(t, test, "the address published again was not pinged", func() bool {
+		return !up.removed && atomic.LoadUint64(&up.received) > received
+	})
+	test.stop()
+}
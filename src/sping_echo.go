@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"spike"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The characters the padding is made of. They are picked at random, so that the
+// compression of the packets does not shrink the padding away.
+const paddingAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// Represents a probe which pads the traffic to a given size by publishing the
+// ping times to a hub and measuring when they are echoed back. The hub may be
+// shared with other sping processes, so the messages are tagged with the id of
+// the probe and the messages of the others are ignored.
+type hubEcho struct {
+	id           string
+	hub          string
+	subscribeKey string
+	publishKey   string
+	size         int
+	padding      string
+}
+
+// Constructs a new hub echo probe, sending messages of the given size in bytes.
+func newHubEcho(hub string, subscribeKey string, publishKey string, size int) *hubEcho {
+	padding := make([]byte, size)
+	for i := range padding {
+		padding[i] = paddingAlphabet[rand.Intn(len(paddingAlphabet))]
+	}
+
+	echo := new(hubEcho)
+	echo.id = newProbeId()
+	echo.hub = hub
+	echo.subscribeKey = subscribeKey
+	echo.publishKey = publishKey
+	echo.size = size
+	echo.padding = string(padding)
+	return echo
+}
+
+// Subscribes the channel to the echo hub and returns the ping times echoed back.
+func (this *hubEcho) Start(channel *spike.Channel) (<-chan int32, error) {
+	if err := channel.HubSubscribe(this.hub, this.subscribeKey); err != nil {
+		return nil, err
+	}
+	select {
+	case msg := <-channel.OnHubSubscribe:
+		if err := msg.Err(this.hub); err != nil {
+			return nil, err
+		}
+	case <-time.After(5 * time.Second):
+		return nil, errors.New("timed out while subscribing to hub '" + this.hub + "'")
+	}
+
+	times := make(chan int32, 2048)
+	go func() {
+		for msg := range channel.OnHubEvent {
+			parts := strings.SplitN(msg.Message, ":", 4)
+			if msg.HubName != this.hub || len(parts) != 4 || parts[0] != "sping" || parts[1] != this.id {
+				continue
+			}
+			if t, err := strconv.ParseInt(parts[2], 10, 32); err == nil {
+				times <- int32(t)
+			}
+		}
+	}()
+	return times, nil
+}
+
+// Publishes the ping time tagged with the id of the probe, padded to its size.
+func (this *hubEcho) Send(channel *spike.Channel, now int32) error {
+	message := "sping:" + this.id + ":" + strconv.Itoa(int(now)) + ":"
+	if len(message) < this.size {
+		message += this.padding[:this.size-len(message)]
+	}
+	return channel.HubPublish(this.hub, this.publishKey, message)
+}
+
+// Converts the pongs of the channel into the ping times they carry.
+func pingTimes(channel *spike.Channel) <-chan int32 {
+	times := make(chan int32, 2048)
+	go func() {
+		for msg := range channel.OnPing {
+			times <- msg.Time
+		}
+	}()
+	return times
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHubEchoIgnoresOtherProbes(t *testing.T) {
+	emulator := newTestEmulator(t)
+	size := 4096
+
+	ours := newHubEcho("echo", "key", "key", size)
+	channel := dialTestEmulator(t, emulator, size+1024)
+	times, err := ours.Start(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another sping shares the echo hub
+	theirs := newHubEcho("echo", "key", "key", size)
+	other := dialTestEmulator(t, emulator, size+1024)
+	if _, err := theirs.Start(other); err != nil {
+		t.Fatal(err)
+	}
+
+	theirs.Send(other, 7)
+	ours.Send(channel, 42)
+	select {
+	case sent := <-times:
+		if sent != 42 {
+			t.Fatalf("received the ping time %d, want 42", sent)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the padded probe was not echoed")
+	}
+
+	select {
+	case sent := <-times:
+		t.Errorf("received the ping time %d of another probe", sent)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
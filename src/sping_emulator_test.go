@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"spike"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The keys of the packets the emulator understands.
+const (
+	pingKey              uint32 = 0xB0AF6283
+	getServerTimeKey     uint32 = 0x33E7FBD1
+	supplyCredentialsKey uint32 = 0x8D98E9FC
+	revokeCredentialsKey uint32 = 0x4AC51818
+	hubSubscribeKey      uint32 = 0x2DD19B9B
+	hubUnsubscribeKey    uint32 = 0x6C63B75
+	hubPublishKey        uint32 = 0x96B41079
+	hubEventKey          uint32 = 0x65B2818C
+)
+
+// Represents a local stand-in for a Spike Engine service, which answers the pings,
+// the server time and the credentials, and echoes every message published to a hub,
+// padding included, to the subscribers of the hub.
+type testEmulator struct {
+	listener    net.Listener
+	guard       *sync.Mutex
+	subscribers map[string]map[*emulatorConn]bool
+	conns       map[*emulatorConn]bool
+	dropPings   int32 // non-zero to leave the pings unanswered, set atomically
+}
+
+// Represents a connection accepted by the emulator.
+type emulatorConn struct {
+	conn  net.Conn
+	guard *sync.Mutex
+}
+
+// Starts an emulator on a local port, stopped when the test ends.
+func newTestEmulator(t *testing.T) *testEmulator {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emulator := &testEmulator{
+		listener:    listener,
+		guard:       new(sync.Mutex),
+		subscribers: make(map[string]map[*emulatorConn]bool),
+		conns:       make(map[*emulatorConn]bool),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go emulator.accept()
+	return emulator
+}
+
+// Returns the address the emulator listens on.
+func (this *testEmulator) Address() string {
+	return this.listener.Addr().String()
+}
+
+// Leaves the pings received from now on unanswered, as if they were lost.
+func (this *testEmulator) DropPings() {
+	atomic.StoreInt32(&this.dropPings, 1)
+}
+
+// Closes every connection accepted so far, as a restarting server would. New
+// connections are still accepted.
+func (this *testEmulator) DropConnections() {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	for client := range this.conns {
+		client.conn.Close()
+	}
+}
+
+// Accepts the connections until the listener is closed.
+func (this *testEmulator) accept() {
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			return
+		}
+		client := &emulatorConn{conn: conn, guard: new(sync.Mutex)}
+		this.guard.Lock()
+		this.conns[client] = true
+		this.guard.Unlock()
+		go this.serve(client)
+	}
+}
+
+// Answers the packets of the connection until it is closed.
+func (this *testEmulator) serve(client *emulatorConn) {
+	defer this.forget(client)
+	defer client.conn.Close()
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(client.conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[0:4])-4)
+		if _, err := io.ReadFull(client.conn, body); err != nil {
+			return
+		}
+
+		key := binary.BigEndian.Uint32(header[4:8])
+		reader := spike.NewPacketReader(body)
+		reply := spike.NewPacketWriter()
+		switch key {
+		case pingKey:
+			if atomic.LoadInt32(&this.dropPings) != 0 {
+				continue
+			}
+			sent, _ := reader.ReadInt32()
+			reply.WriteInt32(sent)
+		case getServerTimeKey:
+			reply.WriteDateTime(time.Now())
+			reply.Compress()
+		case supplyCredentialsKey, revokeCredentialsKey:
+			reply.WriteBoolean(true)
+		case hubSubscribeKey, hubUnsubscribeKey:
+			reader.Decompress()
+			hub, _ := reader.ReadString()
+			this.subscribe(client, hub, key == hubSubscribeKey)
+			reply.WriteInt16(0)
+		case hubPublishKey:
+			reader.Decompress()
+			hub, _ := reader.ReadString()
+			reader.ReadString()
+			message, _ := reader.ReadString()
+			reply.WriteInt16(0)
+			client.send(key, reply)
+			this.publish(hub, message)
+			continue
+		default:
+			return
+		}
+		client.send(key, reply)
+	}
+}
+
+// Adds the connection to the subscribers of the hub, or removes it.
+func (this *testEmulator) subscribe(client *emulatorConn, hub string, subscribe bool) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	if this.subscribers[hub] == nil {
+		this.subscribers[hub] = make(map[*emulatorConn]bool)
+	}
+	if subscribe {
+		this.subscribers[hub][client] = true
+	} else {
+		delete(this.subscribers[hub], client)
+	}
+}
+
+// Forgets the connection and removes it from the subscribers of every hub.
+func (this *testEmulator) forget(client *emulatorConn) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	delete(this.conns, client)
+	for _, subscribers := range this.subscribers {
+		delete(subscribers, client)
+	}
+}
+
+// Echoes the message, untouched, to every subscriber of the hub.
+func (this *testEmulator) publish(hub string, message string) {
+	this.guard.Lock()
+	subscribers := make([]*emulatorConn, 0, len(this.subscribers[hub]))
+	for client := range this.subscribers[hub] {
+		subscribers = append(subscribers, client)
+	}
+	this.guard.Unlock()
+
+	for _, client := range subscribers {
+		event := spike.NewPacketWriter()
+		event.WriteString(hub)
+		event.WriteString(message)
+		event.WriteDateTime(time.Now())
+		event.Compress()
+		client.send(hubEventKey, event)
+	}
+}
+
+// Writes a packet to the connection.
+func (this *emulatorConn) send(key uint32, writer *spike.PacketWriter) {
+	body := writer.Bytes()
+	packet := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(packet[0:4], uint32(len(body)+4))
+	binary.BigEndian.PutUint32(packet[4:8], key)
+
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	this.conn.Write(append(packet, body...))
+}
+
+// Connects a channel to the emulator, disconnected when the test ends.
+func dialTestEmulator(t *testing.T, emulator *testEmulator, bufferSize int) *spike.Channel {
+	channel, err := spike.Dial(spike.TcpDialer{}, emulator.Address(), bufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { channel.Disconnect() })
+	return channel
+}
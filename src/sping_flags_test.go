@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/cli"
+)
+
+// Fails the test if two flags of the command share a name, which makes the
+// command refuse to start.
+func checkUniqueFlags(t *testing.T, path string, flags []cli.Flag, commands []cli.Command) {
+	seen := make(map[string]bool)
+	for _, flag := range flags {
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			name = strings.TrimSpace(name)
+			if seen[name] {
+				t.Errorf("'%s' defines the flag --%s twice", path, name)
+			}
+			seen[name] = true
+		}
+	}
+
+	for _, command := range commands {
+		checkUniqueFlags(t, path+" "+command.Name, command.Flags, command.Subcommands)
+	}
+}
+
+func TestFlagsAreUnique(t *testing.T) {
+	app := newApp()
+	checkUniqueFlags(t, app.Name, app.Flags, app.Commands)
+}
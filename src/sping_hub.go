@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"spike"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// Represents a hub event, as written out in the JSON lines format.
+type hubEventLine struct {
+	Hub     string    `json:"hub"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Returns the command group which exercises the hub API.
+func hubCommand() cli.Command {
+	hostFlag := cli.StringFlag{
+		Name:  "host",
+		Value: "127.0.0.1:8002",
+		Usage: "Sets the host and port of the Spike Engine service.",
+	}
+	keyFlag := cli.StringFlag{
+		Name:  "key",
+		Value: "",
+		Usage: "Sets the key used to subscribe or publish to the hub.",
+	}
+	timeoutFlag := cli.StringFlag{
+		Name:  "timeout",
+		Value: "5s",
+		Usage: "Sets how long to wait for the service to acknowledge a request.",
+	}
+
+	return cli.Command{
+		Name:  "hub",
+		Usage: "Subscribes to or publishes messages on a hub.",
+		Subcommands: []cli.Command{
+			{
+				Name:      "subscribe",
+				Usage:     "Subscribes to a hub and streams the received events to the standard output.",
+				ArgsUsage: "NAME",
+				Flags: append([]cli.Flag{
+					hostFlag,
+					keyFlag,
+					timeoutFlag,
+					cli.StringFlag{
+						Name:  "format",
+						Value: "text",
+						Usage: "Sets the output format of the events: 'text' or 'json' for JSON lines.",
+					},
+					cli.StringFlag{
+						Name:  "heartbeat",
+						Value: "",
+						Usage: "Pings the service at this interval while no event arrives, such as '5s', to detect a dead connection and reconnect. Disabled if empty.",
+					},
+					cli.StringFlag{
+						Name:  "heartbeat-timeout",
+						Value: "",
+						Usage: "Sets how long nothing may be received before the connection is declared dead, three heartbeat intervals if empty.",
+					},
+				}, append(credentialsFlags, dialFlags...)...),
+				Action: func(c *cli.Context) {
+					defer exitOnPanic()
+					if len(c.Args()) != 1 {
+						panic("expected exactly one hub name")
+					}
+					if c.String("format") != "text" && c.String("format") != "json" {
+						panic("unknown format '" + c.String("format") + "'")
+					}
+
+					hub := c.Args()[0]
+					credentials := credentialsFromContext(c)
+					client := connectHubClient(c, credentials)
+
+					// Unsubscribe on CTRL+C
+					schan := make(chan os.Signal, 1)
+					signal.Notify(schan, os.Interrupt)
+					go func() {
+						<-schan
+						if err := client.Unsubscribe(hub); err != nil {
+							fmt.Fprintln(os.Stderr, "Unable to unsubscribe:", err)
+						}
+						revoke(client.Channel())
+						client.Close()
+						os.Exit(0)
+					}()
+
+					events, err := client.Subscribe(hub, c.String("key"))
+					if err != nil {
+						panic(err)
+					}
+
+					encoder := json.NewEncoder(os.Stdout)
+					for {
+						select {
+						case msg := <-events:
+							if c.String("format") == "json" {
+								encoder.Encode(hubEventLine{msg.HubName, msg.Message, msg.Time})
+								continue
+							}
+
+							fmt.Println(msg.Time.Format("2006-01-02 15:04:05.000"), msg.HubName, msg.Message)
+						case err := <-client.OnError:
+							fmt.Fprintln(os.Stderr, "Error:", err)
+						case <-client.OnReconnect:
+							fmt.Fprintln(os.Stderr, "Reconnected to", c.String("host"))
+						}
+					}
+				},
+			},
+			{
+				Name:      "publish",
+				Usage:     "Publishes a message to a hub, or every line of the standard input if the message is '-'.",
+				ArgsUsage: "NAME MESSAGE|-",
+				Flags: append([]cli.Flag{
+					hostFlag,
+					keyFlag,
+					timeoutFlag,
+				}, append(credentialsFlags, dialFlags...)...),
+				Action: func(c *cli.Context) {
+					defer exitOnPanic()
+					if len(c.Args()) < 2 {
+						panic("expected a hub name and a message")
+					}
+
+					hub := c.Args()[0]
+					message := strings.Join(c.Args()[1:], " ")
+					credentials := credentialsFromContext(c)
+					channel := connectHub(c, credentials)
+					defer channel.Disconnect()
+					defer revoke(channel)
+
+					if message != "-" {
+						if err := channel.HubPublish(hub, c.String("key"), message); err != nil {
+							panic(err)
+						}
+						awaitHubStatus(c, channel.OnHubPublish, spike.HubOperationPublish, hub)
+						return
+					}
+
+					scanner := bufio.NewScanner(os.Stdin)
+					for scanner.Scan() {
+						if err := channel.HubPublish(hub, c.String("key"), scanner.Text()); err != nil {
+							panic(err)
+						}
+						awaitHubStatus(c, channel.OnHubPublish, spike.HubOperationPublish, hub)
+					}
+					if err := scanner.Err(); err != nil {
+						panic(err)
+					}
+				},
+			},
+		},
+	}
+}
+
+// Connects to the host specified on the command line and supplies the credentials, if any.
+func connectHub(c *cli.Context, credentials spike.CredentialProvider) *spike.Channel {
+	channel, err := connectChannel(normalizeHost(c.String("host")), 8196, dialOptionsFromContext(c))
+	if err != nil {
+		panic(err)
+	}
+	if err := authenticate(channel, credentials); err != nil {
+		channel.Disconnect()
+		panic(err)
+	}
+	return channel
+}
+
+// Connects a hub client, which reconnects and subscribes again on its own, to the
+// host specified on the command line.
+func connectHubClient(c *cli.Context, credentials spike.CredentialProvider) *spike.HubClient {
+	host := normalizeHost(c.String("host"))
+	dialer, err := dialerFor(host, 8196, dialOptionsFromContext(c))
+	if err != nil {
+		panic(err)
+	}
+
+	client := spike.NewHubClient(dialAddress(host), 8196)
+	client.Dialer = dialer
+	client.Credentials = credentials
+	if client.Timeout, err = time.ParseDuration(c.String("timeout")); err != nil {
+		panic(err)
+	}
+	if c.String("heartbeat") != "" {
+		if client.Heartbeat, err = time.ParseDuration(c.String("heartbeat")); err != nil {
+			panic(err)
+		}
+	}
+	if c.String("heartbeat-timeout") != "" {
+		if client.HeartbeatTimeout, err = time.ParseDuration(c.String("heartbeat-timeout")); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Waits for a publish acknowledgement and panics if it failed or did not arrive in time.
+func awaitHubStatus(c *cli.Context, inform <-chan *spike.HubPublishInform, operation spike.HubOperation, hub string) {
+	timeout, err := time.ParseDuration(c.String("timeout"))
+	if err != nil {
+		panic(err)
+	}
+
+	// Reading in place leaves nothing behind to steal the next acknowledgement
+	var msg *spike.HubPublishInform
+	select {
+	case msg = <-inform:
+	case <-time.After(timeout):
+		panic("timed out while trying to " + string(operation) + " hub '" + hub + "'")
+	}
+
+	if err := spike.HubStatus(msg.Status).Err(operation, hub); err != nil {
+		panic(err)
+	}
+}
+
+// Recovers from a panic, prints a nicer message and exits with a failure.
+func exitOnPanic() {
+	if r := recover(); r != nil {
+		fmt.Fprintln(os.Stderr, "Error:", r)
+		os.Exit(1)
+	}
+}
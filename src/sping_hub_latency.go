@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"spike"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// Represents an end-to-end hub publish/subscribe latency probe.
+type hubLatencyProbe struct {
+	id      string
+	hub     string
+	timeout time.Duration
+
+	guard      *sync.Mutex
+	published  map[int64]time.Time
+	delivered  map[int64]time.Time
+	latencies  []float64
+	sent       int
+	lost       int
+	late       int
+	reordered  int
+	duplicates int
+	highest    int64
+}
+
+// Returns the command which measures the hub publish-to-event latency.
+func hubLatencyCommand() cli.Command {
+	return cli.Command{
+		Name:      "hub-latency",
+		Usage:     "Measures the delivery latency, loss and ordering of messages published to a hub.",
+		ArgsUsage: "[host]",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "hub",
+				Value: "sping",
+				Usage: "Sets the name of the hub to subscribe and publish to.",
+			},
+			cli.StringFlag{
+				Name:  "subscribe-key",
+				Value: "",
+				Usage: "Sets the key used to subscribe to the hub.",
+			},
+			cli.StringFlag{
+				Name:  "publish-key",
+				Value: "",
+				Usage: "Sets the key used to publish to the hub.",
+			},
+			cli.StringFlag{
+				Name:  "interval",
+				Value: "250ms",
+				Usage: "Sets the interval between two published messages.",
+			},
+			cli.IntFlag{
+				Name:  "count",
+				Value: 0,
+				Usage: "Stops after publishing this many messages. Runs until interrupted if zero.",
+			},
+			cli.StringFlag{
+				Name:  "timeout",
+				Value: "5s",
+				Usage: "Sets how long to wait for a published message before considering it lost.",
+			},
+		}, append(credentialsFlags, dialFlags...)...),
+		Action: func(c *cli.Context) {
+			defer exitOnPanic()
+
+			host := "127.0.0.1:8002"
+			if len(c.Args()) > 0 {
+				host = c.Args()[0]
+			}
+			host = normalizeHost(host)
+
+			interval, err := time.ParseDuration(c.String("interval"))
+			if err != nil {
+				panic(err)
+			}
+
+			probe := new(hubLatencyProbe)
+			probe.id = newProbeId()
+			probe.hub = c.String("hub")
+			probe.guard = new(sync.Mutex)
+			probe.published = make(map[int64]time.Time)
+			probe.delivered = make(map[int64]time.Time)
+			if probe.timeout, err = time.ParseDuration(c.String("timeout")); err != nil {
+				panic(err)
+			}
+
+			// Subscribe on one channel
+			credentials := credentialsFromContext(c)
+			subscriber, err := connectChannel(host, 8196, dialOptionsFromContext(c))
+			if err != nil {
+				panic(err)
+			}
+			if err := authenticate(subscriber, credentials); err != nil {
+				panic(err)
+			}
+			if err := subscriber.HubSubscribe(probe.hub, c.String("subscribe-key")); err != nil {
+				panic(err)
+			}
+			select {
+			case msg := <-subscriber.OnHubSubscribe:
+				if err := msg.Err(probe.hub); err != nil {
+					panic(err)
+				}
+			case <-time.After(probe.timeout):
+				panic("timed out while subscribing to hub '" + probe.hub + "'")
+			}
+
+			// Publish from another
+			publisher, err := connectChannel(host, 8196, dialOptionsFromContext(c))
+			if err != nil {
+				panic(err)
+			}
+			if err := authenticate(publisher, credentials); err != nil {
+				panic(err)
+			}
+
+			// Revoke the credentials on the way out
+			shutdown := func() {
+				revoke(subscriber)
+				revoke(publisher)
+			}
+
+			fmt.Println("Measuring hub latency of", probe.hub, "on", host)
+			go probe.receive(subscriber)
+
+			// Hook CTRL+C
+			schan := make(chan os.Signal, 1)
+			signal.Notify(schan, os.Interrupt)
+			go func() {
+				<-schan
+				shutdown()
+				probe.report()
+				os.Exit(0)
+			}()
+
+			// Publish loop
+			for seq := int64(1); c.Int("count") == 0 || seq <= int64(c.Int("count")); seq++ {
+				now := time.Now()
+				probe.guard.Lock()
+				probe.published[seq] = now
+				probe.sent++
+				probe.guard.Unlock()
+
+				if err := publisher.HubPublish(probe.hub, c.String("publish-key"), timingMessage(probe.id, seq, now)); err != nil {
+					fmt.Println("Lost the connection to", host, err)
+					break
+				}
+				probe.expire()
+				time.Sleep(interval)
+			}
+
+			// Wait for the last messages to arrive
+			time.Sleep(probe.timeout)
+			shutdown()
+			probe.report()
+		},
+	}
+}
+
+// Receives the hub events and matches them with the published messages.
+func (this *hubLatencyProbe) receive(channel *spike.Channel) {
+	for msg := range channel.OnHubEvent {
+		received := time.Now()
+		if msg.HubName != this.hub {
+			continue
+		}
+
+		seq, nanos, ok := parseTimingMessage(this.id, msg.Message)
+		if !ok {
+			continue
+		}
+
+		if latency, ok := this.deliver(seq, received.UnixNano()-nanos, msg.HubName); ok {
+			fmt.Printf("Message %d delivered from %s in %.3f ms.\n", seq, msg.HubName, latency)
+		}
+	}
+}
+
+// Returns a random id which tags the messages this process publishes to a hub, so
+// the messages other processes publish to the same hub are told apart.
+func newProbeId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Formats a message which carries the id of the probe, its sequence number and
+// the time it was sent.
+func timingMessage(id string, seq int64, sent time.Time) string {
+	return "sping:" + id + ":" + strconv.FormatInt(seq, 10) + ":" + strconv.FormatInt(sent.UnixNano(), 10)
+}
+
+// Parses a message formatted by timingMessage and returns its sequence number and
+// the time it was sent, in nanoseconds. Returns false if it is not a message of
+// the probe, such as one published by another process.
+func parseTimingMessage(id string, message string) (int64, int64, bool) {
+	parts := strings.Split(message, ":")
+	if len(parts) != 4 || parts[0] != "sping" || parts[1] != id {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	nanos, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return seq, nanos, true
+}
+
+// Records the delivery of a message, which took the number of nanoseconds, and
+// returns its latency in milliseconds. Returns false if it was not expected.
+func (this *hubLatencyProbe) deliver(seq int64, nanos int64, hub string) (float64, bool) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+
+	// The message is either pending, delivered recently, or was already counted
+	// as lost, in which case it is late rather than delivered
+	sent, pending := this.published[seq]
+	if !pending {
+		_, duplicate := this.delivered[seq]
+		switch {
+		case duplicate:
+			this.duplicates++
+			fmt.Println("Duplicate message", seq, "received from", hub)
+		case seq >= 1 && seq <= int64(this.sent):
+			this.late++
+			fmt.Println("Message", seq, "received from", hub, "after it was counted as lost")
+		}
+		return 0, false
+	}
+	delete(this.published, seq)
+	this.delivered[seq] = sent
+	if seq < this.highest {
+		this.reordered++
+	} else {
+		this.highest = seq
+	}
+
+	latency := float64(nanos) / float64(time.Millisecond)
+	this.latencies = append(this.latencies, latency)
+	return latency, true
+}
+
+// Marks the messages which were not delivered within the timeout as lost, and
+// forgets the messages delivered before, which can no longer be told apart
+// from late ones.
+func (this *hubLatencyProbe) expire() {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	for seq, sent := range this.published {
+		if time.Now().Sub(sent) > this.timeout {
+			fmt.Println("Message", seq, "lost")
+			delete(this.published, seq)
+			this.lost++
+		}
+	}
+	for seq, sent := range this.delivered {
+		if time.Now().Sub(sent) > this.timeout {
+			delete(this.delivered, seq)
+		}
+	}
+}
+
+// Prints the delivery statistics.
+func (this *hubLatencyProbe) report() {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+
+	// Everything still pending at this point has not been delivered
+	lost := this.lost + len(this.published)
+
+	fmt.Println()
+	fmt.Println("Hub delivery statistics:")
+	fmt.Println("   Published:    ", this.sent, "messages")
+	fmt.Println("   Delivered:    ", len(this.latencies), "messages")
+	fmt.Println("   Lost:         ", lost, "messages")
+	fmt.Println("   Late:         ", this.late, "messages, counted as lost")
+	fmt.Println("   Out of order: ", this.reordered, "messages")
+	fmt.Println("   Duplicates:   ", this.duplicates, "messages")
+	if len(this.latencies) > 0 {
+		printSummary("Latency statistics:", summarize(this.latencies))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Constructs a probe which has published the messages 1 to count at the times.
+func newTestHubProbe(timeout time.Duration, sent ...time.Time) *hubLatencyProbe {
+	probe := &hubLatencyProbe{
+		id:        "test",
+		hub:       "sping",
+		timeout:   timeout,
+		guard:     new(sync.Mutex),
+		published: make(map[int64]time.Time),
+		delivered: make(map[int64]time.Time),
+	}
+	for i, at := range sent {
+		probe.published[int64(i+1)] = at
+		probe.sent++
+	}
+	return probe
+}
+
+func TestHubLatencyLateMessageIsNotDelivered(t *testing.T) {
+	now := time.Now()
+	probe := newTestHubProbe(time.Second, now.Add(-2*time.Second), now)
+
+	probe.expire()
+	if probe.lost != 1 {
+		t.Fatalf("%d messages lost, want 1", probe.lost)
+	}
+
+	// The expired message arrives after all
+	if _, ok := probe.deliver(1, int64(2*time.Second), "sping"); ok {
+		t.Error("a message counted as lost was also delivered")
+	}
+	if _, ok := probe.deliver(2, int64(time.Millisecond), "sping"); !ok {
+		t.Error("a pending message was not delivered")
+	}
+	if probe.late != 1 || len(probe.latencies) != 1 || probe.duplicates != 0 {
+		t.Errorf("late %d, delivered %d, duplicates %d, want 1, 1 and 0", probe.late, len(probe.latencies), probe.duplicates)
+	}
+}
+
+func TestHubLatencyDuplicatesAndOrder(t *testing.T) {
+	now := time.Now()
+	probe := newTestHubProbe(time.Second, now, now, now)
+
+	probe.deliver(2, 0, "sping")
+	probe.deliver(1, 0, "sping")
+	probe.deliver(2, 0, "sping")
+	probe.deliver(7, 0, "sping")
+	if probe.reordered != 1 || probe.duplicates != 1 || probe.late != 0 || len(probe.latencies) != 2 {
+		t.Errorf("reordered %d, duplicates %d, late %d, delivered %d, want 1, 1, 0 and 2",
+			probe.reordered, probe.duplicates, probe.late, len(probe.latencies))
+	}
+}
+
+func TestHubLatencyForgetsDeliveredMessages(t *testing.T) {
+	probe := newTestHubProbe(time.Second, time.Now().Add(-2*time.Second))
+	probe.deliver(1, 0, "sping")
+	probe.expire()
+	if len(probe.delivered) != 0 || len(probe.published) != 0 {
+		t.Errorf("%d delivered and %d published messages kept, want none", len(probe.delivered), len(probe.published))
+	}
+}
+
+func TestTimingMessagesOfOtherProbesAreIgnored(t *testing.T) {
+	sent := time.Unix(0, 1234567890)
+	ours, theirs := newProbeId(), newProbeId()
+	if ours == theirs {
+		t.Fatal("two probes have the same id")
+	}
+
+	seq, nanos, ok := parseTimingMessage(ours, timingMessage(ours, 7, sent))
+	if !ok || seq != 7 || nanos != sent.UnixNano() {
+		t.Errorf("parsed %d and %d (%v), want 7 and %d", seq, nanos, ok, sent.UnixNano())
+	}
+	for _, message := range []string{timingMessage(theirs, 7, sent), "sping:7:1234567890", "sping:" + ours + ":x:1", "hello"} {
+		if _, _, ok := parseTimingMessage(ours, message); ok {
+			t.Errorf("the message %q was accepted", message)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// Binds the sockets of the dialer to the network interface, so the connections
+// go through it whatever the routing table says.
+func bindInterface(dialer *net.Dialer, name string, network string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return err
+	}
+
+	dialer.Control = func(_ string, _ string, conn syscall.RawConn) error {
+		var err error
+		conn.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		return err
+	}
+	return nil
+}
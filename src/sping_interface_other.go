@@ -0,0 +1,30 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// Binds the dialer to the first address of the network interface in the address
+// family of the network, as sockets cannot be bound to a device on this platform.
+func bindInterface(dialer *net.Dialer, name string, network string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	addresses, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	options := dialOptions{network: network}
+	for _, address := range addresses {
+		if ip, ok := address.(*net.IPNet); ok && options.accepts(ip.IP) && !ip.IP.IsLinkLocalUnicast() {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip.IP}
+			return nil
+		}
+	}
+	return errors.New("sping: interface " + name + " has no usable address")
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"spike"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// Represents the statistics of a single phase of a load test.
+type loadPhase struct {
+	Name     string
+	Start    time.Time
+	End      time.Time
+	sent     int64
+	received int64
+	errors   int64
+	lost     int64
+
+	guard     *sync.Mutex
+	latencies []float64
+}
+
+// Records a successful round-trip, in milliseconds.
+func (this *loadPhase) success(rtt float64) {
+	atomic.AddInt64(&this.received, 1)
+	this.guard.Lock()
+	this.latencies = append(this.latencies, rtt)
+	this.guard.Unlock()
+}
+
+// Represents a request which has been sent and awaits for its response.
+type loadPending struct {
+	sent  time.Time
+	phase *loadPhase
+}
+
+// Represents a single connection driven by the load generator.
+type loadConn struct {
+	channel   *spike.Channel
+	work      chan *loadPhase
+	guard     *sync.Mutex
+	sequence  int32
+	pings     map[int32]loadPending
+	publishes []loadPending
+	expired   int
+}
+
+// Represents a phase of the schedule which drives the traffic.
+type loadStage struct {
+	name     string
+	duration time.Duration
+	ramp     bool
+}
+
+// Represents the requests accumulated at a varying rate, the fractions of
+// requests being carried over from one tick to the next.
+type loadBudget struct {
+	budget float64
+	last   time.Time
+}
+
+// Returns the number of whole requests due at the rate since the last time.
+func (this *loadBudget) due(now time.Time, rate float64) int {
+	this.budget += rate * now.Sub(this.last).Seconds()
+	this.last = now
+	due := int(this.budget)
+	this.budget -= float64(due)
+	return due
+}
+
+// Represents a load generator.
+type loadTest struct {
+	connections int
+	rate        float64
+	rampUp      time.Duration
+	duration    time.Duration
+	traffic     string
+	hub         string
+	publishKey  string
+	message     string
+	timeout     time.Duration
+	dial        dialOptions
+	credentials spike.CredentialProvider
+
+	conns   []*loadConn
+	guard   *sync.Mutex
+	phases  []*loadPhase
+	counter uint64
+}
+
+// Returns the command which runs the load generator.
+func loadCommand() cli.Command {
+	return cli.Command{
+		Name:      "load",
+		Usage:     "Opens many connections and drives Ping and/or HubPublish traffic at a target aggregate rate.",
+		ArgsUsage: "[host]",
+		Flags: append([]cli.Flag{
+			cli.IntFlag{
+				Name:  "connections",
+				Value: 100,
+				Usage: "Sets the number of concurrent connections to open.",
+			},
+			cli.StringFlag{
+				Name:  "rate",
+				Value: "1000/s",
+				Usage: "Sets the target aggregate rate of requests, such as '10000/s' or '600/m'.",
+			},
+			cli.StringFlag{
+				Name:  "duration",
+				Value: "1m",
+				Usage: "Sets the duration of the steady phase, at the target rate.",
+			},
+			cli.StringFlag{
+				Name:  "ramp-up",
+				Value: "10s",
+				Usage: "Sets the duration of the ramp-up phase, during which the rate grows linearly up to the target rate.",
+			},
+			cli.StringFlag{
+				Name:  "traffic",
+				Value: "ping",
+				Usage: "Sets the kind of traffic to generate: 'ping', 'publish' or 'mixed'.",
+			},
+			cli.StringFlag{
+				Name:  "hub",
+				Value: "sping",
+				Usage: "Sets the hub to publish to when generating publish traffic.",
+			},
+			cli.StringFlag{
+				Name:  "publish-key",
+				Value: "",
+				Usage: "Sets the key used to publish to the hub.",
+			},
+			cli.StringFlag{
+				Name:  "message",
+				Value: "sping",
+				Usage: "Sets the message published to the hub.",
+			},
+			cli.StringFlag{
+				Name:  "timeout",
+				Value: "5s",
+				Usage: "Counts a request as lost if unanswered within this time.",
+			},
+		}, append(credentialsFlags, dialFlags...)...),
+		Action: func(c *cli.Context) {
+			defer exitOnPanic()
+
+			host := "127.0.0.1:8002"
+			if len(c.Args()) > 0 {
+				host = c.Args()[0]
+			}
+
+			test := new(loadTest)
+			test.guard = new(sync.Mutex)
+			test.connections = c.Int("connections")
+			test.traffic = c.String("traffic")
+			test.hub = c.String("hub")
+			test.publishKey = c.String("publish-key")
+			test.message = c.String("message")
+			test.dial = dialOptionsFromContext(c)
+			test.credentials = credentialsFromContext(c)
+
+			var err error
+			if test.rate, err = parseRate(c.String("rate")); err != nil {
+				panic(err)
+			}
+			if test.rampUp, err = time.ParseDuration(c.String("ramp-up")); err != nil {
+				panic(err)
+			}
+			if test.duration, err = time.ParseDuration(c.String("duration")); err != nil {
+				panic(err)
+			}
+			if test.timeout, err = time.ParseDuration(c.String("timeout")); err != nil {
+				panic(err)
+			}
+			if test.traffic != "ping" && test.traffic != "publish" && test.traffic != "mixed" {
+				panic("unknown traffic '" + test.traffic + "'")
+			}
+
+			test.Run(normalizeHost(host))
+		},
+	}
+}
+
+// Parses a rate such as '10000/s', '600/m' or '10000' and returns the number of operations per second.
+func parseRate(value string) (float64, error) {
+	unit := time.Second
+	if i := strings.Index(value, "/"); i >= 0 {
+		var err error
+		if unit, err = time.ParseDuration("1" + value[i+1:]); err != nil {
+			return 0, errors.New("sping: invalid rate unit in '" + value + "'")
+		}
+		value = value[:i]
+	}
+
+	count, err := strconv.ParseFloat(value, 64)
+	if err != nil || count <= 0 {
+		return 0, errors.New("sping: invalid rate '" + value + "'")
+	}
+	return count / unit.Seconds(), nil
+}
+
+// Runs the load test against the host and prints the report.
+func (this *loadTest) Run(host string) {
+	connect := this.startPhase("connect")
+
+	// Open the connections
+	fmt.Println("Opening", this.connections, "connections to", host)
+	for i := 0; i < this.connections; i++ {
+		start := time.Now()
+		channel, err := connectChannel(host, 8196, this.dial)
+		if err == nil {
+			if err = authenticate(channel, this.credentials); err != nil {
+				channel.Disconnect()
+			}
+		}
+		if err != nil {
+			connect.errors++
+			continue
+		}
+		connect.success(float64(time.Now().Sub(start)) / float64(time.Millisecond))
+
+		conn := &loadConn{
+			channel: channel,
+			work:    make(chan *loadPhase, 1024),
+			guard:   new(sync.Mutex),
+			pings:   make(map[int32]loadPending),
+		}
+		this.conns = append(this.conns, conn)
+		go this.receive(conn)
+		go this.send(conn)
+	}
+
+	connect.end()
+	connect.sent = int64(this.connections)
+	if len(this.conns) == 0 {
+		panic("unable to open any connection")
+	}
+
+	// Print the report on CTRL+C
+	schan := make(chan os.Signal, 1)
+	signal.Notify(schan, os.Interrupt)
+	go func() {
+		<-schan
+		this.stop()
+		this.report(os.Stdout)
+		os.Exit(0)
+	}()
+	go this.reap()
+
+	// Drive the phases
+	for _, stage := range this.schedule() {
+		this.drive(stage)
+	}
+
+	// Give the outstanding requests a chance to complete, the others are lost
+	time.Sleep(this.timeout)
+	this.expire(time.Now().Add(time.Hour))
+	this.stop()
+	this.report(os.Stdout)
+}
+
+// Returns the phases which drive the traffic: the ramp-up if any, then the steady phase.
+func (this *loadTest) schedule() []loadStage {
+	stages := make([]loadStage, 0, 2)
+	if this.rampUp > 0 {
+		stages = append(stages, loadStage{"ramp-up", this.rampUp, true})
+	}
+	return append(stages, loadStage{"steady", this.duration, false})
+}
+
+// Returns the target rate at the time elapsed into the stage, which grows
+// linearly from zero during a ramp-up.
+func (this *loadTest) rateAt(stage loadStage, elapsed time.Duration) float64 {
+	if !stage.ramp {
+		return this.rate
+	}
+	return this.rate * elapsed.Seconds() / stage.duration.Seconds()
+}
+
+// Starts a new phase.
+func (this *loadTest) startPhase(name string) *loadPhase {
+	phase := &loadPhase{Name: name, Start: time.Now(), guard: new(sync.Mutex)}
+	this.guard.Lock()
+	this.phases = append(this.phases, phase)
+	this.guard.Unlock()
+	return phase
+}
+
+// Ends the phase.
+func (this *loadPhase) end() {
+	this.guard.Lock()
+	this.End = time.Now()
+	this.guard.Unlock()
+}
+
+// Counts the requests unanswered within the timeout as lost, periodically.
+func (this *loadTest) reap() {
+	for now := range time.Tick(100 * time.Millisecond) {
+		this.expire(now)
+	}
+}
+
+// Counts the requests unanswered within the timeout, as of the time, as lost.
+func (this *loadTest) expire(now time.Time) {
+	deadline := now.Add(-this.timeout)
+	for _, conn := range this.conns {
+		conn.guard.Lock()
+		for sequence, pending := range conn.pings {
+			if pending.sent.Before(deadline) {
+				delete(conn.pings, sequence)
+				atomic.AddInt64(&pending.phase.lost, 1)
+			}
+		}
+
+		// The publishes are answered in order, so a late answer belongs to an expired one
+		for len(conn.publishes) > 0 && conn.publishes[0].sent.Before(deadline) {
+			atomic.AddInt64(&conn.publishes[0].phase.lost, 1)
+			conn.publishes = conn.publishes[1:]
+			conn.expired++
+		}
+		conn.guard.Unlock()
+	}
+}
+
+// Revokes the credentials and closes every connection.
+func (this *loadTest) stop() {
+	for _, conn := range this.conns {
+		revoke(conn.channel)
+		conn.channel.Disconnect()
+	}
+}
+
+// Drives the traffic for a phase of the schedule.
+func (this *loadTest) drive(stage loadStage) {
+	name := stage.name
+	phase := this.startPhase(name)
+	fmt.Println("Starting phase", name, "for", stage.duration)
+
+	budget := &loadBudget{last: phase.Start}
+	progress := phase.Start
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		elapsed := now.Sub(phase.Start)
+		if elapsed >= stage.duration {
+			break
+		}
+
+		// Distribute the requests due since the last tick among the connections, round-robin
+		rate := this.rateAt(stage, elapsed)
+		for due := budget.due(now, rate); due > 0; due-- {
+			conn := this.conns[atomic.AddUint64(&this.counter, 1)%uint64(len(this.conns))]
+			select {
+			case conn.work <- phase:
+			default:
+				atomic.AddInt64(&phase.errors, 1)
+			}
+		}
+
+		if now.Sub(progress) >= time.Second {
+			progress = now
+			fmt.Printf("%s: %v elapsed, %.0f req/s target, %d sent, %d received, %d lost, %d errors\n", name, elapsed/time.Second*time.Second,
+				rate, atomic.LoadInt64(&phase.sent), atomic.LoadInt64(&phase.received), atomic.LoadInt64(&phase.lost), atomic.LoadInt64(&phase.errors))
+		}
+	}
+
+	phase.end()
+}
+
+// Sends the requests scheduled on the connection.
+func (this *loadTest) send(conn *loadConn) {
+	publish := this.traffic == "publish"
+	for phase := range conn.work {
+		if this.traffic == "mixed" {
+			publish = !publish
+		}
+
+		if err := this.request(conn, phase, publish); err != nil {
+			atomic.AddInt64(&phase.errors, 1)
+			continue
+		}
+		atomic.AddInt64(&phase.sent, 1)
+	}
+}
+
+// Sends a single request, or returns why it could not be sent.
+func (this *loadTest) request(conn *loadConn, phase *loadPhase, publish bool) error {
+	pending := loadPending{time.Now(), phase}
+	conn.guard.Lock()
+	if publish {
+		conn.publishes = append(conn.publishes, pending)
+		conn.guard.Unlock()
+		return conn.channel.HubPublish(this.hub, this.publishKey, this.message)
+	}
+
+	conn.sequence++
+	sequence := conn.sequence
+	conn.pings[sequence] = pending
+	conn.guard.Unlock()
+	return conn.channel.Ping(sequence)
+}
+
+// Receives the responses on the connection and matches them with the requests.
+func (this *loadTest) receive(conn *loadConn) {
+	for {
+		select {
+		case msg := <-conn.channel.OnPing:
+			conn.guard.Lock()
+			pending, ok := conn.pings[msg.Time]
+			delete(conn.pings, msg.Time)
+			conn.guard.Unlock()
+			if ok {
+				pending.phase.success(float64(time.Now().Sub(pending.sent)) / float64(time.Millisecond))
+			}
+
+		case msg := <-conn.channel.OnHubPublish:
+			conn.guard.Lock()
+			if conn.expired > 0 {
+				// Answers a publish already counted as lost
+				conn.expired--
+				conn.guard.Unlock()
+				continue
+			}
+			if len(conn.publishes) == 0 {
+				conn.guard.Unlock()
+				continue
+			}
+			pending := conn.publishes[0]
+			conn.publishes = conn.publishes[1:]
+			conn.guard.Unlock()
+
+			if msg.Err(this.hub) != nil {
+				atomic.AddInt64(&pending.phase.errors, 1)
+				continue
+			}
+			pending.phase.success(float64(time.Now().Sub(pending.sent)) / float64(time.Millisecond))
+		}
+	}
+}
+
+// Prints the report, with a line per phase.
+func (this *loadTest) report(out io.Writer) {
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Load statistics per phase:")
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Phase\tDuration\tSent\tReceived\tLost\tErrors\tThroughput\t50th\t90th\t99th\tMax\t")
+
+	this.guard.Lock()
+	phases := append([]*loadPhase{}, this.phases...)
+	this.guard.Unlock()
+	for _, phase := range phases {
+		phase.guard.Lock()
+		end := phase.End
+		latencies := append([]float64{}, phase.latencies...)
+		phase.guard.Unlock()
+
+		if end.IsZero() {
+			end = time.Now()
+		}
+		duration := end.Sub(phase.Start)
+
+		received := atomic.LoadInt64(&phase.received)
+		fmt.Fprintf(w, "%s\t%v\t%d\t%d\t%d\t%d\t", phase.Name, duration/time.Millisecond*time.Millisecond,
+			atomic.LoadInt64(&phase.sent), received, atomic.LoadInt64(&phase.lost), atomic.LoadInt64(&phase.errors))
+		if len(latencies) == 0 {
+			fmt.Fprintf(w, "-\t-\t-\t-\t-\t\n")
+			continue
+		}
+
+		s := summarize(latencies)
+		fmt.Fprintf(w, "%.1f/s\t%.2f ms\t%.2f ms\t%.2f ms\t%.2f ms\t\n", float64(received)/duration.Seconds(), s.P50, s.P90, s.P99, s.Max)
+	}
+	w.Flush()
+}
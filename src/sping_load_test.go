@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadSchedule(t *testing.T) {
+	test := &loadTest{rate: 100, rampUp: 10 * time.Second, duration: time.Minute}
+	stages := test.schedule()
+	if len(stages) != 2 || stages[0] != (loadStage{"ramp-up", 10 * time.Second, true}) || stages[1] != (loadStage{"steady", time.Minute, false}) {
+		t.Fatalf("the schedule is %+v, want a ramp-up then a steady phase", stages)
+	}
+
+	// The rate grows linearly during the ramp-up, then holds
+	for elapsed, want := range map[time.Duration]float64{0: 0, 2500 * time.Millisecond: 25, 10 * time.Second: 100} {
+		if got := test.rateAt(stages[0], elapsed); math.Abs(got-want) > 1e-9 {
+			t.Errorf("the ramp-up rate after %v is %g, want %g", elapsed, got, want)
+		}
+	}
+	if got := test.rateAt(stages[1], time.Second); got != 100 {
+		t.Errorf("the steady rate is %g, want 100", got)
+	}
+
+	test.rampUp = 0
+	if stages := test.schedule(); len(stages) != 1 || stages[0].name != "steady" {
+		t.Errorf("the schedule without a ramp-up is %+v", stages)
+	}
+}
+
+func TestLoadBudgetCarriesFractions(t *testing.T) {
+	start := time.Now()
+	budget := &loadBudget{last: start}
+
+	// 150 requests per second over ticks of 10ms is 1.5 requests per tick
+	total := 0
+	for tick := 1; tick <= 100; tick++ {
+		total += budget.due(start.Add(time.Duration(tick)*10*time.Millisecond), 150)
+	}
+	if total != 150 {
+		t.Errorf("%d requests were due in a second at 150/s", total)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for value, want := range map[string]float64{"1000/s": 1000, "600/m": 10, "50": 50} {
+		if got, err := parseRate(value); err != nil || got != want {
+			t.Errorf("parseRate(%q) = %g, %v, want %g", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "-5/s", "10/x"} {
+		if _, err := parseRate(value); err == nil {
+			t.Errorf("parseRate(%q) succeeded", value)
+		}
+	}
+}
+
+func TestLoadAgainstTheEmulator(t *testing.T) {
+	emulator := newTestEmulator(t)
+	test := &loadTest{
+		guard:       new(sync.Mutex),
+		connections: 3,
+		rate:        300,
+		rampUp:      100 * time.Millisecond,
+		duration:    300 * time.Millisecond,
+		traffic:     "mixed",
+		hub:         "sping",
+		message:     "load",
+		timeout:     200 * time.Millisecond,
+	}
+	test.Run(emulator.Address())
+
+	if len(test.phases) != 3 {
+		t.Fatalf("%d phases ran, want connect, ramp-up and steady", len(test.phases))
+	}
+	for _, phase := range test.phases {
+		if phase.received == 0 || phase.lost != 0 || phase.errors != 0 || phase.received != phase.sent {
+			t.Errorf("phase %s sent %d, received %d, lost %d, errors %d", phase.Name, phase.sent, phase.received, phase.lost, phase.errors)
+		}
+	}
+
+	// The steady phase runs at about the target rate
+	if steady := test.phases[2]; steady.sent < 60 || steady.sent > 120 {
+		t.Errorf("the steady phase sent %d requests in 300ms at 300/s", steady.sent)
+	}
+
+	out := new(bytes.Buffer)
+	test.report(out)
+	for _, name := range []string{"connect", "ramp-up", "steady"} {
+		if !strings.Contains(out.String(), name) {
+			t.Errorf("the report has no line for %s:\n%s", name, out.String())
+		}
+	}
+	// The 50th, 90th and 99th percentiles and the maximum of every phase
+	if got := strings.Count(out.String(), " ms"); got != 12 {
+		t.Errorf("the report has %d latencies, want 12:\n%s", got, out.String())
+	}
+}
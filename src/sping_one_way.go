@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"spike"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// Returns the command which estimates the upstream and downstream delays separately.
+func oneWayCommand() cli.Command {
+	return cli.Command{
+		Name:      "one-way",
+		Usage:     "Estimates the upstream and downstream one-way delays, using the server clock offset and the server time of hub events.",
+		ArgsUsage: "[host]",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "hub",
+				Value: "sping",
+				Usage: "Sets the name of the hub the timestamped messages are published to and received from.",
+			},
+			cli.StringFlag{
+				Name:  "subscribe-key",
+				Value: "",
+				Usage: "Sets the key used to subscribe to the hub.",
+			},
+			cli.StringFlag{
+				Name:  "publish-key",
+				Value: "",
+				Usage: "Sets the key used to publish to the hub.",
+			},
+			cli.StringFlag{
+				Name:  "offset",
+				Value: "auto",
+				Usage: "Sets the offset of the server clock, such as '0s' if both clocks are synchronized with NTP, or 'auto' to estimate it with GetServerTime. An estimated offset assumes a symmetric path, so only a known offset reveals a constant asymmetry.",
+			},
+			cli.IntFlag{
+				Name:  "clock-samples",
+				Value: 20,
+				Usage: "Sets the number of GetServerTime samples taken to estimate the offset.",
+			},
+			cli.StringFlag{
+				Name:  "interval",
+				Value: "250ms",
+				Usage: "Sets the interval between two published messages.",
+			},
+			cli.IntFlag{
+				Name:  "count",
+				Value: 0,
+				Usage: "Stops after publishing this many messages. Runs until interrupted if zero.",
+			},
+		}, append(credentialsFlags, dialFlags...)...),
+		Action: func(c *cli.Context) {
+			defer exitOnPanic()
+
+			host := "127.0.0.1:8002"
+			if len(c.Args()) > 0 {
+				host = c.Args()[0]
+			}
+			host = normalizeHost(host)
+			hub := c.String("hub")
+
+			interval, err := time.ParseDuration(c.String("interval"))
+			if err != nil {
+				panic(err)
+			}
+
+			credentials := credentialsFromContext(c)
+			channel, err := connectChannel(host, 8196, dialOptionsFromContext(c))
+			if err != nil {
+				panic(err)
+			}
+			if err := authenticate(channel, credentials); err != nil {
+				panic(err)
+			}
+
+			// The offset of the server clock, either given or estimated
+			var clock spike.ClockEstimate
+			if c.String("offset") == "auto" {
+				samples := make([]spike.ClockSample, 0)
+				for i := 0; i < c.Int("clock-samples"); i++ {
+					if sample, err := channel.SampleClock(5 * time.Second); err == nil {
+						samples = append(samples, sample)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				if clock, err = spike.EstimateClock(samples, 5); err != nil {
+					panic(err)
+				}
+				fmt.Printf("Estimated the server clock offset: %+.3f ms ± %.3f ms.\n", millis(clock.Offset), millis(clock.Uncertainty))
+			} else if clock.Offset, err = time.ParseDuration(c.String("offset")); err != nil {
+				panic(err)
+			}
+
+			// Publish and receive on the same connection, so both directions share the route
+			if err := channel.HubSubscribe(hub, c.String("subscribe-key")); err != nil {
+				panic(err)
+			}
+			select {
+			case msg := <-channel.OnHubSubscribe:
+				if err := msg.Err(hub); err != nil {
+					panic(err)
+				}
+			case <-time.After(5 * time.Second):
+				panic("timed out while subscribing to hub '" + hub + "'")
+			}
+
+			var guard sync.Mutex
+			var upstream, downstream []float64
+			report := func() {
+				revoke(channel)
+				guard.Lock()
+				defer guard.Unlock()
+				if len(upstream) == 0 {
+					fmt.Println("No message was delivered.")
+					return
+				}
+
+				up := summarize(upstream)
+				down := summarize(downstream)
+				printSummary("Upstream statistics:", up)
+				printSummary("Downstream statistics:", down)
+				fmt.Println()
+				fmt.Printf("Asymmetry (median upstream - downstream): %+.3f ms.\n", up.Median-down.Median)
+				fmt.Printf("Every estimate is within ± %.3f ms, the clock uncertainty and the server time resolution.\n", millis(clock.Uncertainty+time.Millisecond/2))
+			}
+
+			// Tag the messages, so the ones published by another sping to the hub are ignored
+			id := newProbeId()
+			go func() {
+				for msg := range channel.OnHubEvent {
+					received := time.Now()
+					if msg.HubName != hub {
+						continue
+					}
+					seq, nanos, ok := parseTimingMessage(id, msg.Message)
+					if !ok {
+						continue
+					}
+
+					up, down := clock.OneWay(time.Unix(0, nanos), msg.Time, received)
+					guard.Lock()
+					upstream = append(upstream, millis(up))
+					downstream = append(downstream, millis(down))
+					guard.Unlock()
+					fmt.Printf("Message %d: upstream %.3f ms, downstream %.3f ms.\n", seq, millis(up), millis(down))
+				}
+			}()
+
+			// Hook CTRL+C
+			schan := make(chan os.Signal, 1)
+			signal.Notify(schan, os.Interrupt)
+			go func() {
+				<-schan
+				report()
+				os.Exit(0)
+			}()
+
+			fmt.Println("Measuring one-way delays to", host, "through hub", hub)
+			for seq := 1; c.Int("count") == 0 || seq <= c.Int("count"); seq++ {
+				message := timingMessage(id, int64(seq), time.Now())
+				if err := channel.HubPublish(hub, c.String("publish-key"), message); err != nil {
+					fmt.Println("Lost the connection to", host, err)
+					break
+				}
+				time.Sleep(interval)
+			}
+
+			time.Sleep(time.Second)
+			report()
+		},
+	}
+}
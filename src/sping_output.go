@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Represents a sink where the latency samples and periodic summaries are pushed.
+type Output interface {
+	// Pushes a single round-trip time, in milliseconds, measured against a target.
+	Rtt(target string, probe string, rtt float64) error
+
+	// Pushes a summary of the samples collected during the last period.
+	Summary(target string, probe string, summary Summary) error
+
+	// Flushes and closes the output.
+	Close() error
+}
+
+// Represents a set of outputs which are written to together.
+type Outputs []Output
+
+// Pushes the round-trip time to every output.
+func (this Outputs) Rtt(target string, probe string, rtt float64) (err error) {
+	for _, o := range this {
+		if e := o.Rtt(target, probe, rtt); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Pushes the summary to every output.
+func (this Outputs) Summary(target string, probe string, summary Summary) (err error) {
+	for _, o := range this {
+		if e := o.Summary(target, probe, summary); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Closes every output.
+func (this Outputs) Close() (err error) {
+	for _, o := range this {
+		if e := o.Close(); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// The last failure to push to the outputs, which is reported once.
+var outputFailure struct {
+	sync.Mutex
+	last string
+}
+
+// Reports a failure to push to the outputs on the standard error, unless it
+// repeats the previous one, so an unreachable sink does not flood it. A success
+// rearms the report.
+func reportOutput(err error) {
+	outputFailure.Lock()
+	defer outputFailure.Unlock()
+	if err == nil {
+		outputFailure.last = ""
+		return
+	}
+	if err.Error() != outputFailure.last {
+		outputFailure.last = err.Error()
+		fmt.Fprintln(os.Stderr, "Unable to push to the outputs:", err)
+	}
+}
+
+// ------------------ StatsD ------------------------
+
+// Represents an output which pushes the metrics to a StatsD daemon over UDP.
+type StatsdOutput struct {
+	conn   net.Conn
+	prefix string
+}
+
+// Constructs a new StatsD output for the address, such as '127.0.0.1:8125'.
+func NewStatsdOutput(address string, prefix string) (*StatsdOutput, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	output := new(StatsdOutput)
+	output.conn = conn
+	output.prefix = prefix
+	return output, nil
+}
+
+// Builds the metric name, tagged by target host and probe name.
+func (this *StatsdOutput) metric(target string, probe string, name string) string {
+	return strings.Join([]string{this.prefix, statsdEscape(probe), statsdEscape(target), name}, ".")
+}
+
+// Pushes a single round-trip time as a StatsD timer.
+func (this *StatsdOutput) Rtt(target string, probe string, rtt float64) error {
+	_, err := fmt.Fprintf(this.conn, "%s:%g|ms", this.metric(target, probe, "rtt"), rtt)
+	return err
+}
+
+// Pushes the summary as a batch of StatsD gauges.
+func (this *StatsdOutput) Summary(target string, probe string, summary Summary) error {
+	buffer := new(bytes.Buffer)
+	for _, field := range summaryFields(summary) {
+		fmt.Fprintf(buffer, "%s:%g|g\n", this.metric(target, probe, field.name), field.value)
+	}
+
+	_, err := this.conn.Write(bytes.TrimRight(buffer.Bytes(), "\n"))
+	return err
+}
+
+// Closes the underlying socket.
+func (this *StatsdOutput) Close() error {
+	return this.conn.Close()
+}
+
+// Replaces the characters which have a special meaning in StatsD metric names.
+func statsdEscape(value string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "/", "_", "[", "", "]", "").Replace(value)
+}
+
+// ------------------ InfluxDB ------------------------
+
+// Represents an output which pushes the metrics using the InfluxDB line protocol.
+type InfluxOutput struct {
+	address string
+	writer  io.WriteCloser
+	guard   *sync.Mutex
+}
+
+// Constructs a new InfluxDB line-protocol output. The address is an URL with
+// one of the 'udp://host:port', 'http://host:port/write?db=name' or
+// 'file:///path/to/file' forms.
+func NewInfluxOutput(address string) (*InfluxOutput, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	output := new(InfluxOutput)
+	output.address = address
+	output.guard = new(sync.Mutex)
+
+	switch u.Scheme {
+	case "udp":
+		output.writer, err = net.Dial("udp", u.Host)
+	case "http", "https":
+		output.writer = newInfluxHttpWriter(address, influxFlushInterval, influxPostTimeout, os.Stderr)
+	case "file":
+		output.writer, err = os.OpenFile(u.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	default:
+		err = errors.New("sping: unsupported InfluxDB output scheme '" + u.Scheme + "'")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Writes the lines to the underlying sink.
+func (this *InfluxOutput) write(lines string) error {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	_, err := io.WriteString(this.writer, lines)
+	return err
+}
+
+// Pushes a single round-trip time as a 'sping_rtt' point.
+func (this *InfluxOutput) Rtt(target string, probe string, rtt float64) error {
+	return this.write(fmt.Sprintf("sping_rtt,%s value=%g %d\n", influxTags(target, probe), rtt, time.Now().UnixNano()))
+}
+
+// Pushes the summary as a 'sping_summary' point.
+func (this *InfluxOutput) Summary(target string, probe string, summary Summary) error {
+	fields := make([]string, 0)
+	for _, field := range summaryFields(summary) {
+		fields = append(fields, fmt.Sprintf("%s=%g", field.name, field.value))
+	}
+
+	return this.write(fmt.Sprintf("sping_summary,%s %s %d\n", influxTags(target, probe), strings.Join(fields, ","), time.Now().UnixNano()))
+}
+
+// Closes the underlying sink.
+func (this *InfluxOutput) Close() error {
+	return this.writer.Close()
+}
+
+// Builds the tag set of a point.
+func influxTags(target string, probe string) string {
+	escape := strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+	return "host=" + escape.Replace(target) + ",probe=" + escape.Replace(probe)
+}
+
+// The period at which the buffered lines are posted to an InfluxDB HTTP endpoint.
+const influxFlushInterval = time.Second
+
+// The number of bytes buffered for an InfluxDB HTTP endpoint, beyond which the
+// lines are dropped until the endpoint catches up.
+const influxMaxBuffer = 1 << 20
+
+// How long a post to an InfluxDB HTTP endpoint may take, so a hung endpoint
+// neither stops the pushes for good nor blocks the exit.
+const influxPostTimeout = 5 * time.Second
+
+// Represents a writer which buffers the lines and posts them to an InfluxDB HTTP
+// endpoint in batches from its own goroutine, so a slow endpoint never delays
+// the measurements. Failures are reported on the report writer.
+type influxHttpWriter struct {
+	address  string
+	interval time.Duration
+	client   *http.Client
+	report   io.Writer
+	guard    *sync.Mutex
+	buffer   *bytes.Buffer
+	dropped  int
+	done     chan bool
+	closed   chan bool
+}
+
+// Constructs a new writer for the endpoint and starts posting in the background,
+// every post giving up after the timeout.
+func newInfluxHttpWriter(address string, interval time.Duration, timeout time.Duration, report io.Writer) *influxHttpWriter {
+	writer := new(influxHttpWriter)
+	writer.address = address
+	writer.interval = interval
+	writer.client = &http.Client{Timeout: timeout}
+	writer.report = report
+	writer.guard = new(sync.Mutex)
+	writer.buffer = new(bytes.Buffer)
+	writer.done = make(chan bool)
+	writer.closed = make(chan bool)
+	go writer.run()
+	return writer
+}
+
+// Buffers the lines, or drops them if the buffer is full.
+func (this *influxHttpWriter) Write(p []byte) (int, error) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	if this.buffer.Len()+len(p) > influxMaxBuffer {
+		this.dropped += bytes.Count(p, []byte("\n"))
+		return len(p), nil
+	}
+	return this.buffer.Write(p)
+}
+
+// Posts the buffered lines every interval, and once more when closed.
+func (this *influxHttpWriter) run() {
+	defer close(this.closed)
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			this.flush()
+		case <-this.done:
+			this.flush()
+			return
+		}
+	}
+}
+
+// Posts the buffered lines and reports the failures.
+func (this *influxHttpWriter) flush() {
+	this.guard.Lock()
+	batch := append([]byte{}, this.buffer.Bytes()...)
+	this.buffer.Reset()
+	dropped := this.dropped
+	this.dropped = 0
+	this.guard.Unlock()
+
+	if dropped > 0 {
+		fmt.Fprintln(this.report, "Dropped", dropped, "points, the InfluxDB endpoint", this.address, "is too slow")
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := this.post(batch); err != nil {
+		fmt.Fprintln(this.report, "Unable to push", bytes.Count(batch, []byte("\n")), "points to InfluxDB:", err)
+	}
+}
+
+// Posts the lines to the HTTP endpoint.
+func (this *influxHttpWriter) post(lines []byte) error {
+	response, err := this.client.Post(this.address, "text/plain; charset=utf-8", bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return errors.New("sping: InfluxDB endpoint returned " + response.Status)
+	}
+	return nil
+}
+
+// Posts the remaining lines and stops.
+func (this *influxHttpWriter) Close() error {
+	close(this.done)
+	<-this.closed
+	return nil
+}
+
+// ------------------ Helpers ------------------------
+
+// Represents a named value of the summary.
+type summaryField struct {
+	name  string
+	value float64
+}
+
+// Flattens the summary into a list of named values.
+func summaryFields(s Summary) []summaryField {
+	return []summaryField{
+		{"samples", float64(s.Samples)},
+		{"min", s.Min},
+		{"max", s.Max},
+		{"mean", s.Mean},
+		{"median", s.Median},
+		{"variance", s.Variance},
+		{"p50", s.P50},
+		{"p90", s.P90},
+		{"p95", s.P95},
+		{"p99", s.P99},
+	}
+}
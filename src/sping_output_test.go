@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Listens on a local UDP port and returns the datagrams received.
+func listenUdp(t *testing.T) (*net.UDPConn, <-chan string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datagrams := make(chan string, 16)
+	go func() {
+		buffer := make([]byte, 65536)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				close(datagrams)
+				return
+			}
+			datagrams <- string(buffer[:n])
+		}
+	}()
+	return conn, datagrams
+}
+
+// Returns the next datagram, failing the test if none arrives.
+func nextDatagram(t *testing.T, datagrams <-chan string) string {
+	select {
+	case datagram := <-datagrams:
+		return datagram
+	case <-time.After(2 * time.Second):
+		t.Fatal("no datagram received")
+	}
+	return ""
+}
+
+func TestStatsdOutput(t *testing.T) {
+	conn, datagrams := listenUdp(t)
+	defer conn.Close()
+
+	output, err := NewStatsdOutput(conn.LocalAddr().String(), "sping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	if err := output.Rtt("[::1]:8002", "edge", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nextDatagram(t, datagrams), "sping.edge.__1_8002.rtt:1.5|ms"; got != want {
+		t.Errorf("rtt datagram is %q, want %q", got, want)
+	}
+
+	if err := output.Summary("host:80", "edge", summarize([]float64{1, 2, 3})); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(nextDatagram(t, datagrams), "\n")
+	if len(lines) != len(summaryFields(Summary{})) {
+		t.Fatalf("summary datagram has %d lines, want %d", len(lines), len(summaryFields(Summary{})))
+	}
+	if lines[0] != "sping.edge.host_80.samples:3|g" || lines[3] != "sping.edge.host_80.mean:2|g" {
+		t.Errorf("unexpected summary datagram %q", lines)
+	}
+}
+
+func TestInfluxUdpOutput(t *testing.T) {
+	conn, datagrams := listenUdp(t)
+	defer conn.Close()
+
+	output, err := NewInfluxOutput("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	if err := output.Rtt("a b,c", "edge", 2.25); err != nil {
+		t.Fatal(err)
+	}
+	line := nextDatagram(t, datagrams)
+	if !strings.HasPrefix(line, `sping_rtt,host=a\ b\,c,probe=edge value=2.25 `) || !strings.HasSuffix(line, "\n") {
+		t.Errorf("unexpected point %q", line)
+	}
+}
+
+func TestInfluxFileOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "points.txt")
+	output, err := NewInfluxOutput("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output.Rtt("host", "edge", 1)
+	output.Summary("host", "edge", summarize([]float64{1}))
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "sping_rtt,") || !strings.HasPrefix(lines[1], "sping_summary,") {
+		t.Errorf("unexpected points %q", lines)
+	}
+}
+
+func TestInfluxHttpWriterDoesNotBlock(t *testing.T) {
+	var guard sync.Mutex
+	var body bytes.Buffer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A slow collector must not delay the writes
+		time.Sleep(200 * time.Millisecond)
+		content, _ := ioutil.ReadAll(r.Body)
+		guard.Lock()
+		body.Write(content)
+		guard.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	report := new(bytes.Buffer)
+	writer := newInfluxHttpWriter(server.URL, 10*time.Millisecond, time.Second, report)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		writer.Write([]byte("sping_rtt,host=h value=1 1\n"))
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("the writes took %v, they must not wait for the endpoint", elapsed)
+	}
+
+	writer.Close()
+	guard.Lock()
+	defer guard.Unlock()
+	if got := strings.Count(body.String(), "\n"); got != 10 {
+		t.Errorf("the endpoint received %d points, want 10", got)
+	}
+	if report.Len() > 0 {
+		t.Errorf("unexpected report %q", report.String())
+	}
+}
+
+func TestInfluxHttpWriterReportsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	report := new(bytes.Buffer)
+	writer := newInfluxHttpWriter(server.URL, time.Hour, time.Second, report)
+	writer.Write([]byte("sping_rtt,host=h value=1 1\nsping_rtt,host=h value=2 2\n"))
+	writer.Close()
+
+	if !strings.Contains(report.String(), "Unable to push 2 points to InfluxDB") || !strings.Contains(report.String(), "404") {
+		t.Errorf("unexpected report %q", report.String())
+	}
+}
+
+func TestInfluxHttpWriterGivesUpOnAHungEndpoint(t *testing.T) {
+	hung := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	report := new(bytes.Buffer)
+	writer := newInfluxHttpWriter(server.URL, time.Hour, 50*time.Millisecond, report)
+	writer.Write([]byte("sping_rtt,host=h value=1 1\n"))
+
+	closed := make(chan bool)
+	go func() {
+		writer.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("closing waits for a hung endpoint")
+	}
+	if !strings.Contains(report.String(), "Unable to push 1 points to InfluxDB") {
+		t.Errorf("unexpected report %q", report.String())
+	}
+}
+
+func TestReportOutputOnce(t *testing.T) {
+	stderr := os.Stderr
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = write
+	defer func() { os.Stderr = stderr }()
+
+	// Start from a success, whatever an earlier run reported
+	reportOutput(nil)
+	failure := &net.OpError{Op: "write", Net: "udp", Err: os.ErrDeadlineExceeded}
+	reportOutput(failure)
+	reportOutput(failure)
+	reportOutput(nil)
+	reportOutput(failure)
+	write.Close()
+
+	content, _ := ioutil.ReadAll(read)
+	if got := strings.Count(string(content), "Unable to push"); got != 2 {
+		t.Errorf("reported %d times, want 2: %q", got, content)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// Represents a configuration file with named probe profiles. A profile sets the
+// flags of a sping command by their names, such as 'interval' or 'statsd', so
+// every flag of the command line can be configured.
+type Config struct {
+	// The path of the file the configuration was read from.
+	Path string `yaml:"-" toml:"-"`
+
+	// The flags applied to every profile whose command has them.
+	Defaults map[string]interface{} `yaml:"defaults" toml:"defaults"`
+
+	// The probe profiles, by name.
+	Probes map[string]Profile `yaml:"probes" toml:"probes"`
+}
+
+// Represents a named probe: the command to run, its flags and its targets. The
+// 'command' key names the sping command, such as 'check' or 'hub subscribe', and
+// pings if empty. The 'target' or 'targets' and 'args' keys are the arguments
+// of the command. Every other key is a flag of the command.
+type Profile map[string]interface{}
+
+// The keys of a profile which are not flags.
+var profileKeys = map[string]bool{"command": true, "target": true, "targets": true, "args": true}
+
+// The files searched for a configuration, in order, when none is given.
+func configPaths() []string {
+	paths := []string{"sping.yaml", "sping.yml", "sping.json", "sping.toml"}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "sping", "sping.yaml"))
+	}
+	return append(paths, "/etc/sping/sping.yaml")
+}
+
+// Reads the configuration from the file, in TOML if its extension is '.toml', or
+// else in YAML or JSON. If the path is empty, reads the SPING_CONFIG environment
+// variable or searches the default locations.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv("SPING_CONFIG")
+	}
+	if path == "" {
+		for _, candidate := range configPaths() {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path == "" {
+		return nil, errors.New("no configuration found, use --config or SPING_CONFIG")
+	}
+
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is a subset of YAML, a single parser reads both
+	config := new(Config)
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(buffer, config)
+	} else {
+		err = yaml.Unmarshal(buffer, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.Path, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Returns the names of the profiles, sorted.
+func (this *Config) Names() []string {
+	names := make([]string, 0, len(this.Probes))
+	for name := range this.Probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builds the command line which runs the profile, defaults included.
+func (this *Config) Arguments(app *cli.App, name string) ([]string, error) {
+	profile, ok := this.Probes[name]
+	if !ok {
+		return nil, errors.New("unknown profile '" + name + "'")
+	}
+
+	command := profile.Command()
+	flags, err := commandFlags(app, command)
+	if err != nil {
+		return nil, fmt.Errorf("profile '%s': %v", name, err)
+	}
+
+	// The defaults only apply to the commands which have them
+	values := make(map[string]interface{})
+	for key, value := range this.Defaults {
+		if flags[key] {
+			values[key] = value
+		}
+	}
+	for key, value := range profile {
+		if profileKeys[key] {
+			continue
+		}
+		if !flags[key] {
+			return nil, fmt.Errorf("profile '%s': unknown flag '%s' for the %s command", name, key, commandName(command))
+		}
+		values[key] = value
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := append([]string{app.Name}, command...)
+	for _, key := range keys {
+		args = append(args, "--"+key+"="+flagValue(values[key]))
+	}
+	for _, key := range []string{"target", "targets", "args"} {
+		args = append(args, listValue(profile[key])...)
+	}
+	return args, nil
+}
+
+// Parses the flags and arguments of the profile as its command would, without
+// running it.
+func (this *Config) Context(name string) (*cli.Context, error) {
+	app := newApp()
+	args, err := this.Arguments(app, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Swap the action of the command for one which keeps its context
+	var context *cli.Context
+	capture := func(c *cli.Context) {
+		context = c
+	}
+	command, _ := lookupCommand(app, this.Probes[name].Command())
+	if command == nil {
+		app.Action = capture
+	} else {
+		command.Action = capture
+	}
+
+	if err := app.Run(args); err != nil {
+		return nil, fmt.Errorf("profile '%s': %v", name, err)
+	}
+	if context == nil {
+		return nil, fmt.Errorf("profile '%s': invalid command line", name)
+	}
+	return context, nil
+}
+
+// Returns the words of the command of the profile, empty to ping.
+func (this Profile) Command() []string {
+	return strings.Fields(fmt.Sprint(valueOr(this["command"], "")))
+}
+
+// Finds the command, or returns nil for the application itself if empty.
+func lookupCommand(app *cli.App, command []string) (*cli.Command, error) {
+	var found *cli.Command
+	commands := app.Commands
+	for _, word := range command {
+		found = nil
+		for i := range commands {
+			if commands[i].HasName(word) {
+				found = &commands[i]
+			}
+		}
+		if found == nil {
+			return nil, errors.New("unknown command '" + strings.Join(command, " ") + "'")
+		}
+		commands = found.Subcommands
+	}
+	return found, nil
+}
+
+// Returns the names of the flags of the command, or of the application if empty.
+func commandFlags(app *cli.App, command []string) (map[string]bool, error) {
+	found, err := lookupCommand(app, command)
+	if err != nil {
+		return nil, err
+	}
+	flags := app.Flags
+	if found != nil {
+		flags = found.Flags
+	}
+
+	names := make(map[string]bool)
+	for _, flag := range flags {
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			names[strings.TrimSpace(name)] = true
+		}
+	}
+	return names, nil
+}
+
+// Returns the name of the command, for the error messages.
+func commandName(command []string) string {
+	if len(command) == 0 {
+		return "ping"
+	}
+	return strings.Join(command, " ")
+}
+
+// Formats a configured value as the value of a flag, lists being joined by commas.
+func flagValue(value interface{}) string {
+	return strings.Join(listValue(value), ",")
+}
+
+// Formats a configured value as a list of strings.
+func listValue(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// Returns the value, or the default if it is nil.
+func valueOr(value interface{}, def interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	return value
+}
+
+// Returns the command which runs probe profiles from a configuration file.
+func runCommand() cli.Command {
+	return cli.Command{
+		Name:      "run",
+		Usage:     "Runs the named probe profiles of a YAML, JSON or TOML configuration file, all of them with --all.",
+		ArgsUsage: "PROFILE...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "config",
+				Value: "",
+				Usage: "Reads the profiles from this file. Uses SPING_CONFIG if empty, or the first of ./sping.yaml, ./sping.yml, ./sping.json, ./sping.toml, ~/.config/sping/sping.yaml and /etc/sping/sping.yaml.",
+			},
+			cli.BoolFlag{
+				Name:  "all",
+				Usage: "Runs every profile of the configuration.",
+			},
+			cli.BoolFlag{
+				Name:  "list",
+				Usage: "Prints the profiles along with the command line they run, instead of running them.",
+			},
+		},
+		Action: func(c *cli.Context) {
+			defer exitOnPanic()
+
+			config, err := LoadConfig(c.String("config"))
+			if err != nil {
+				panic(err)
+			}
+
+			names := []string(c.Args())
+			if c.Bool("all") || c.Bool("list") && len(names) == 0 {
+				names = config.Names()
+			}
+			if len(names) == 0 {
+				panic("expected a profile name, or --all")
+			}
+
+			// Build every command line first, so a broken profile runs nothing
+			app := newApp()
+			commands := make([][]string, 0, len(names))
+			for _, name := range names {
+				args, err := config.Arguments(app, name)
+				if err != nil {
+					panic(err)
+				}
+				commands = append(commands, args)
+			}
+
+			switch {
+			case c.Bool("list"):
+				for i, name := range names {
+					fmt.Println(name+":", strings.Join(commands[i], " "))
+				}
+			case len(names) == 1:
+				if err := app.Run(commands[0]); err != nil {
+					panic(err)
+				}
+			default:
+				os.Exit(runProfiles(config, names))
+			}
+		},
+	}
+}
+
+// Runs every profile in its own process, prefixing their output with their name,
+// and returns the worst of their exit codes.
+func runProfiles(config *Config, names []string) int {
+	executable, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+
+	// CTRL+C reaches the profiles too, let them print their statistics
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+
+	var wait sync.WaitGroup
+	var guard sync.Mutex
+	status := statusOk
+	for _, name := range names {
+		process := exec.Command(executable, "run", "--config", config.Path, name)
+		output, err := process.StdoutPipe()
+		if err != nil {
+			panic(err)
+		}
+		process.Stderr = process.Stdout
+		if err := process.Start(); err != nil {
+			panic(err)
+		}
+
+		wait.Add(1)
+		go func(name string) {
+			defer wait.Done()
+			prefixLines(os.Stdout, output, "["+name+"] ", &guard)
+
+			code := statusOk
+			if err := process.Wait(); err != nil {
+				code = statusUnknown
+				if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() > 0 {
+					code = exit.ExitCode()
+				}
+			}
+
+			guard.Lock()
+			status = worstStatus(status, code)
+			guard.Unlock()
+		}(name)
+	}
+
+	wait.Wait()
+	return status
+}
+
+// Copies the lines of the reader to the writer, each one prefixed.
+func prefixLines(w io.Writer, r io.Reader, prefix string, guard *sync.Mutex) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		guard.Lock()
+		fmt.Fprintln(w, prefix+scanner.Text())
+		guard.Unlock()
+	}
+}
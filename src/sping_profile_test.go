@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The same configuration in every supported format.
+var testConfigs = map[string]string{
+	"sping.yaml": `
+defaults:
+  interval: 1s
+probes:
+  edge:
+    command: check
+    count: 3
+    target: 127.0.0.1:8002
+  news:
+    command: hub subscribe
+    key: k
+    args: [news]
+`,
+	"sping.json": `{
+  "defaults": {"interval": "1s"},
+  "probes": {
+    "edge": {"command": "check", "count": 3, "target": "127.0.0.1:8002"},
+    "news": {"command": "hub subscribe", "key": "k", "args": ["news"]}
+  }
+}`,
+	"sping.toml": `
+[defaults]
+interval = "1s"
+
+[probes.edge]
+command = "check"
+count = 3
+target = "127.0.0.1:8002"
+
+[probes.news]
+command = "hub subscribe"
+key = "k"
+args = ["news"]
+`,
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string][]string{
+		"edge": {"sping", "check", "--count=3", "--interval=1s", "127.0.0.1:8002"},
+		"news": {"sping", "hub", "subscribe", "--key=k", "news"},
+	}
+
+	for file, content := range testConfigs {
+		path := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		for name, args := range want {
+			got, err := config.Arguments(newApp(), name)
+			if err != nil {
+				t.Errorf("%s: %v", file, err)
+			} else if !reflect.DeepEqual(got, args) {
+				t.Errorf("%s: profile '%s' runs %q, want %q", file, name, got, args)
+			}
+		}
+	}
+}
+
+func TestLoadConfigReportsTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sping.toml")
+	ioutil.WriteFile(path, []byte("[probes\n"), 0644)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("an invalid TOML file was loaded")
+	}
+}
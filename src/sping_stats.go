@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/montanaflynn/stats"
+)
+
+// Represents the descriptive statistics of a set of latency samples.
+type Summary struct {
+	Samples  int
+	Min      float64
+	Max      float64
+	Mean     float64
+	Median   float64
+	Variance float64
+	P1       float64
+	P25      float64
+	P50      float64
+	P75      float64
+	P90      float64
+	P95      float64
+	P99      float64
+}
+
+// Computes the summary of the latency samples provided.
+func summarize(samples []float64) Summary {
+	return Summary{
+		Samples:  len(samples),
+		Min:      stats.Min(samples),
+		Max:      stats.Max(samples),
+		Mean:     stats.Mean(samples),
+		Median:   stats.Median(samples),
+		Variance: stats.VarP(samples),
+		P1:       stats.Percentile(samples, 1),
+		P25:      stats.Percentile(samples, 25),
+		P50:      stats.Percentile(samples, 50),
+		P75:      stats.Percentile(samples, 75),
+		P90:      stats.Percentile(samples, 90),
+		P95:      stats.Percentile(samples, 95),
+		P99:      stats.Percentile(samples, 99),
+	}
+}
+
+// Prints the summary to the standard output, under the title provided.
+func printSummary(title string, s Summary) {
+	fmt.Println()
+	fmt.Println(title)
+	fmt.Println("   Samples:  ", s.Samples, "events")
+	fmt.Println("   Min:      ", s.Min, "ms.")
+	fmt.Println("   Max:      ", s.Max, "ms.")
+	fmt.Println("   Mean:     ", s.Mean, "ms.")
+	fmt.Println("   Median:   ", s.Median, "ms.")
+	fmt.Println("   Variance: ", s.Variance, "ms.")
+	fmt.Println()
+	fmt.Println("Percentiles:")
+	fmt.Println("    1st: ", s.P1, "ms.")
+	fmt.Println("   25th: ", s.P25, "ms.")
+	fmt.Println("   50th: ", s.P50, "ms.")
+	fmt.Println("   75th: ", s.P75, "ms.")
+	fmt.Println("   90th: ", s.P90, "ms.")
+	fmt.Println("   95th: ", s.P95, "ms.")
+	fmt.Println("   99th: ", s.P99, "ms.")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"spike"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Represents a single pinged service along with the samples collected so far.
+type Target struct {
+	Host    string
+	Channel *spike.Channel
+	Failed  error
+
+	// Whether the samples are also kept in a window, which the periodic
+	// summaries flush. Disabled by default, as nothing else empties it.
+	Windowed bool
+
+	guard   *sync.Mutex
+	samples []float64
+	window  []float64
+}
+
+// Constructs a new target for the host.
+func NewTarget(host string) *Target {
+	target := new(Target)
+	target.Host = host
+	target.guard = new(sync.Mutex)
+	return target
+}
+
+// Records a round-trip time measured against the target.
+func (this *Target) Record(rtt float64, keep bool) {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	if keep {
+		this.samples = append(this.samples, rtt)
+	}
+	if this.Windowed {
+		this.window = append(this.window, rtt)
+	}
+}
+
+// Returns a copy of all the samples recorded so far.
+func (this *Target) Samples() []float64 {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	return append([]float64{}, this.samples...)
+}
+
+// Returns the samples recorded since the last call and starts a new window.
+func (this *Target) Flush() []float64 {
+	this.guard.Lock()
+	defer this.guard.Unlock()
+	window := this.window
+	this.window = nil
+	return window
+}
+
+// Appends the default port to the host if none was specified, bracketing IPv6
+// literals: 443 for 'tls://' hosts and 80 otherwise. WebSocket URLs are kept as
+// they are.
+func normalizeHost(host string) string {
+	host = strings.TrimPrefix(host, "tcp://")
+	if strings.HasPrefix(host, "tls://") {
+		return "tls://" + withDefaultPort(strings.TrimPrefix(host, "tls://"), "443")
+	}
+	if strings.Contains(host, "://") {
+		return host
+	}
+	return withDefaultPort(host, "80")
+}
+
+// Appends the port to the host if it has none.
+func withDefaultPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port)
+}
+
+// Reads the list of targets from a file, one host per line. Empty lines
+// and lines starting with '#' are ignored.
+func readTargets(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hosts := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	return hosts, scanner.Err()
+}
+
+// Prints a table with a summary line per target.
+func printSummaryTable(targets []*Target) {
+	fmt.Println()
+	fmt.Println("Ping statistics per target:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Host\tSamples\tMin\tMean\tMedian\t95th\t99th\tMax\t")
+	for _, target := range targets {
+		samples := target.Samples()
+		if target.Failed != nil || len(samples) == 0 {
+			fmt.Fprintf(w, "%s\t%d\t-\t-\t-\t-\t-\t-\t\n", target.Host, len(samples))
+			continue
+		}
+
+		s := summarize(samples)
+		fmt.Fprintf(w, "%s\t%d\t%g\t%.2f\t%g\t%g\t%g\t%g\t\n", target.Host, s.Samples, s.Min, s.Mean, s.Median, s.P95, s.P99, s.Max)
+	}
+	w.Flush()
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestTargetWindowOnlyWhenWindowed(t *testing.T) {
+	target := NewTarget("127.0.0.1:8002")
+	target.Record(1, false)
+	target.Record(2, true)
+	if window := target.Flush(); len(window) != 0 {
+		t.Errorf("the window holds %v without an output to flush it", window)
+	}
+	if samples := target.Samples(); len(samples) != 1 || samples[0] != 2 {
+		t.Errorf("the samples are %v, want [2]", samples)
+	}
+
+	target.Windowed = true
+	target.Record(3, false)
+	target.Record(4, false)
+	if window := target.Flush(); len(window) != 2 {
+		t.Errorf("the window is %v, want [3 4]", window)
+	}
+	if window := target.Flush(); len(window) != 0 {
+		t.Errorf("the window is %v after a flush, want it empty", window)
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	cases := map[string]string{
+		"example.com":      "example.com:80",
+		"example.com:8002": "example.com:8002",
+		"10.0.0.1":         "10.0.0.1:80",
+	}
+	checkNormalizeHost(t, cases)
+}
+
+func TestNormalizeHostSchemes(t *testing.T) {
+	cases := map[string]string{
+		"tcp://10.0.0.1":       "10.0.0.1:80",
+		"ws://example.com/api": "ws://example.com/api",
+		"wss://example.com/":   "wss://example.com/",
+	}
+	checkNormalizeHost(t, cases)
+}
+
+func TestNormalizeHostTls(t *testing.T) {
+	cases := map[string]string{
+		"tls://example.com":      "tls://example.com:443",
+		"tls://example.com:8443": "tls://example.com:8443",
+		"tls://::1":              "tls://[::1]:443",
+	}
+	checkNormalizeHost(t, cases)
+}
+
+func TestNormalizeHostIPv6(t *testing.T) {
+	cases := map[string]string{
+		"::1":          "[::1]:80",
+		"[::1]":        "[::1]:80",
+		"[::1]:8002":   "[::1]:8002",
+		"2001:db8::10": "[2001:db8::10]:80",
+	}
+	checkNormalizeHost(t, cases)
+}
+
+// Fails the test unless every host is normalized as expected.
+func checkNormalizeHost(t *testing.T, cases map[string]string) {
+	for host, want := range cases {
+		if got := normalizeHost(host); got != want {
+			t.Errorf("normalizeHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
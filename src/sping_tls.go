@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"spike"
+	"time"
+)
+
+// Represents the thresholds on the number of days before a certificate expires.
+type certThresholds struct {
+	warnDays int
+	critDays int
+}
+
+// Prints the negotiated TLS parameters and the certificate chain of the channel,
+// and returns the status of the earliest expiry against the thresholds. Nothing
+// is printed if the channel is not encrypted.
+func inspectTLS(host string, channel *spike.Channel, thresholds certThresholds) int {
+	state, ok := channel.TLSConnectionState()
+	if !ok {
+		return statusOk
+	}
+
+	alpn := state.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "none"
+	}
+	fmt.Println("TLS to", host+":", tls.VersionName(state.Version)+",", tls.CipherSuiteName(state.CipherSuite)+", ALPN", alpn)
+
+	for i, certificate := range state.PeerCertificates {
+		fmt.Printf("   %d: %s, issued by %s, expires %s (%d days)\n", i, certificate.Subject, certificate.Issuer,
+			certificate.NotAfter.Format("2006-01-02"), daysUntil(certificate.NotAfter))
+	}
+
+	days, _ := certDays(channel)
+	status := thresholds.status(days)
+	switch status {
+	case statusCritical:
+		fmt.Println("Critical: the certificate chain of", host, "expires in", days, "days")
+	case statusWarning:
+		fmt.Println("Warning: the certificate chain of", host, "expires in", days, "days")
+	}
+	return status
+}
+
+// Returns the number of days until the first certificate of the chain expires,
+// as the chain is only as valid as it. Returns false if the channel is not encrypted.
+func certDays(channel *spike.Channel) (int, bool) {
+	state, ok := channel.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return 0, false
+	}
+
+	earliest := state.PeerCertificates[0].NotAfter
+	for _, certificate := range state.PeerCertificates {
+		if certificate.NotAfter.Before(earliest) {
+			earliest = certificate.NotAfter
+		}
+	}
+	return daysUntil(earliest), true
+}
+
+// Returns the status of a certificate chain which expires in the number of days.
+func (this certThresholds) status(days int) int {
+	switch {
+	case this.critDays > 0 && days < this.critDays:
+		return statusCritical
+	case this.warnDays > 0 && days < this.warnDays:
+		return statusWarning
+	}
+	return statusOk
+}
+
+// Returns the status of a failed connection, critical if a certificate has expired.
+func certErrorStatus(host string, err error) int {
+	if certExpired(err) {
+		fmt.Println("Critical: the certificate chain of", host, "has expired")
+		return statusCritical
+	}
+	return statusOk
+}
+
+// Checks whether the connection failed because a certificate has expired.
+func certExpired(err error) bool {
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &invalid) && invalid.Reason == x509.Expired
+}
+
+// Returns the number of whole days until the time, negative once it has passed.
+func daysUntil(t time.Time) int {
+	return int(time.Until(t) / (24 * time.Hour))
+}
+
+// Returns the worst of the two statuses.
+func worstStatus(a int, b int) int {
+	if b > a {
+		return b
+	}
+	return a
+}